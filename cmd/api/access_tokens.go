@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// Create a new personal access token for the current user. Personal access tokens are
+// long-lived, named, and restricted to a subset of the user's permissions, which makes
+// them suitable for scripts and CI jobs.
+func (app *application) createAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// The expiry field is optional, and if it's not provided then the token will
+	// expire in 90 days.
+	var input struct {
+		Name   string     `json:"name"`
+		Scopes []string   `json:"scopes"`
+		Expiry *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	token := &data.Token{
+		Name:   input.Name,
+		Scopes: input.Scopes,
+		Expiry: time.Now().Add(90 * 24 * time.Hour),
+	}
+
+	if input.Expiry != nil {
+		token.Expiry = *input.Expiry
+	}
+
+	v := validator.New()
+
+	if data.ValidatePersonalAccessToken(v, token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Users can only create tokens with scopes that they have permission for
+	// themselves.
+	permissions, err := app.userPermissions(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, scope := range token.Scopes {
+		if !permissions.Include(scope) {
+			v.AddError("scopes", fmt.Sprintf("you don't have the %q permission", scope))
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The token works in the organization that the user is currently working in.
+	token, err = app.models.Tokens.NewPersonalAccess(user.ID, app.contextGetOrganization(r), time.Until(token.Expiry), token.Name, token.Scopes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, user, "token.issue", "token", token.ID, nil, auditToken(token))
+
+	// Send the token in the response. This is the only time that the plaintext token
+	// is available, so the client must store it somewhere safe.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"access_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List the current user's unexpired personal access tokens.
+func (app *application) listAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	tokens, err := app.models.Tokens.GetAllForUser(data.ScopePersonalAccess, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"access_tokens": tokens}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke one of the current user's personal access tokens.
+func (app *application) deleteAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Tokens.DeleteForUser(data.ScopePersonalAccess, id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, user, "token.revoke", "token", id, map[string]any{"scope": data.ScopePersonalAccess}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "access token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
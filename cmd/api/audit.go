@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// The audit() helper records an event in the audit log. The actor is the user who
+// carried out the action; for most handlers this is just contextGetUser(), but when a
+// user is logging in or activating their account the request is anonymous, so the
+// handler passes the user instead. If the actor is being impersonated, then the member
+// of staff impersonating them is recorded too. The before and after values are the target before
+// and after the change (either of which can be nil), and only the fields that differ
+// between them are recorded.
+//
+// A failure to write to the audit log is logged, but doesn't fail the request: by the
+// time we get here the change has already been made, so returning an error to the
+// client would only make them think that it hadn't.
+func (app *application) audit(r *http.Request, actor *data.User, action, targetType string, targetID int64, before, after any) {
+
+	event := &data.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		IP:         app.clientIP(r),
+		RequestID:  app.contextGetRequestID(r),
+	}
+
+	if actor != nil && !actor.IsAnonymous() {
+		event.ActorID = &actor.ID
+
+		if actor.ImpersonatorID != 0 {
+			event.ImpersonatorID = &actor.ImpersonatorID
+		}
+	}
+
+	if targetID != 0 {
+		event.TargetID = strconv.FormatInt(targetID, 10)
+	}
+
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+	event.Changes = changes
+
+	err = app.models.AuditEvents.Insert(event)
+	if err != nil {
+		app.logger.Error(err.Error(), "action", action, "target_type", targetType, "target_id", event.TargetID)
+	}
+}
+
+// The auditToken() helper returns the details of a token to record in the audit log.
+// We never record the token itself, because anyone who could read the audit log would
+// then be able to use it.
+func auditToken(token *data.Token) map[string]any {
+
+	details := map[string]any{
+		"scope":  token.Scope,
+		"expiry": token.Expiry,
+	}
+
+	if token.Name != "" {
+		details["name"] = token.Name
+		details["scopes"] = token.Scopes
+	}
+
+	return details
+}
+
+// The auditChanges() function returns the JSON for the before/after diff of an audit
+// event. Both values are converted to JSON objects (using the same struct tags as our
+// responses, so fields like password hashes are never included), and then any fields
+// which are the same in both are left out. It returns nil if there's nothing to record.
+func auditChanges(before, after any) (json.RawMessage, error) {
+
+	b, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range b {
+		if other, ok := a[key]; ok && reflect.DeepEqual(value, other) {
+			delete(b, key)
+			delete(a, key)
+		}
+	}
+
+	if len(b) == 0 && len(a) == 0 {
+		return nil, nil
+	}
+
+	changes := map[string]map[string]any{}
+
+	if len(b) > 0 {
+		changes["before"] = b
+	}
+
+	if len(a) > 0 {
+		changes["after"] = a
+	}
+
+	return json.Marshal(changes)
+}
+
+// The auditFields() function converts a value to a map of its JSON fields.
+func auditFields(value any) (map[string]any, error) {
+
+	fields := map[string]any{}
+
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Pointer && reflect.ValueOf(value).IsNil() {
+		return fields, nil
+	}
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(js, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// List the events in the audit log, most recent first. The actor_id, action,
+// target_type and target_id query string parameters filter the events.
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		ActorID    int
+		Action     string
+		TargetType string
+		TargetID   string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.ActorID = app.readInt(qs, "actor_id", 0, v)
+	input.Action = app.readString(qs, "action", "")
+	input.TargetType = app.readString(qs, "target_type", "")
+	input.TargetID = app.readString(qs, "target_id", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "action", "-id", "-created_at", "-action"}
+
+	v.Check(input.ActorID >= 0, "actor_id", "must be a positive integer")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.AuditEvents.GetAll(int64(input.ActorID), input.Action, input.TargetType, input.TargetID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// List the collections in the catalog, optionally searching by name.
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "name")
+	input.Filters.SortSafelist = []string{"id", "name", "-id", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collections, metadata, err := app.collections(r).GetAll(input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create a collection. The movie_ids field lists the movies in the collection, in
+// order.
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		MovieIDs    []int64 `json:"movie_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		Name:        input.Name,
+		Description: input.Description,
+	}
+
+	if input.MovieIDs == nil {
+		input.MovieIDs = []int64{}
+	}
+
+	v := validator.New()
+
+	data.ValidateCollection(v, collection)
+	data.ValidateCollectionMovies(v, input.MovieIDs)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.collections(r).Insert(collection, input.MovieIDs)
+	if err != nil {
+		app.collectionErrorResponse(w, r, v, err)
+		return
+	}
+
+	app.audit(r, app.contextGetUser(r), "collection.create", "collection", collection.ID, nil, collectionAudit(collection, input.MovieIDs))
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+
+	app.writeCollectionResponse(w, r, http.StatusCreated, collection, headers)
+}
+
+// Show a collection, along with its movies.
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	collection, ok := app.readCollectionParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeCollectionResponse(w, r, http.StatusOK, collection, nil)
+}
+
+// Change the name or description of a collection, or the movies in it. If movie_ids
+// is given, it replaces the movies in the collection.
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	collection, ok := app.readCollectionParam(w, r)
+	if !ok {
+		return
+	}
+
+	movies, err := app.collections(r).GetMovies(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	before := collectionAudit(collection, movieIDs(movies))
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		MovieIDs    []int64 `json:"movie_ids"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		collection.Name = *input.Name
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+
+	v := validator.New()
+
+	data.ValidateCollection(v, collection)
+	if input.MovieIDs != nil {
+		data.ValidateCollectionMovies(v, input.MovieIDs)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.collections(r).Update(collection, input.MovieIDs)
+	if err != nil {
+		app.collectionErrorResponse(w, r, v, err)
+		return
+	}
+
+	after := collectionAudit(collection, before.MovieIDs)
+	if input.MovieIDs != nil {
+		after.MovieIDs = input.MovieIDs
+	}
+
+	app.audit(r, app.contextGetUser(r), "collection.update", "collection", collection.ID, before, after)
+
+	app.writeCollectionResponse(w, r, http.StatusOK, collection, nil)
+}
+
+// Delete a collection. The movies in it aren't deleted.
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+
+	collection, ok := app.readCollectionParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.collections(r).Delete(collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.contextGetUser(r), "collection.delete", "collection", collection.ID, collection, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The collectionErrorResponse() helper sends the response for an error from inserting
+// or updating a collection.
+func (app *application) collectionErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		v.AddError("movie_ids", "must all be existing movies")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrMovieInOtherCollection):
+		v.AddError("movie_ids", "must not contain movies which are in another collection")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, data.ErrNoOrganization):
+		app.noOrganizationResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The collections() helper returns a CollectionModel for the organization that the
+// user is working in, in the same way as the movies() helper.
+func (app *application) collections(r *http.Request) data.CollectionModel {
+	return app.models.Collections.ForOrganization(app.contextGetOrganization(r))
+}
+
+// The readCollectionParam() helper fetches the collection whose ID is in the URL,
+// sending a 404 Not Found response if there's no such collection.
+func (app *application) readCollectionParam(w http.ResponseWriter, r *http.Request) (*data.Collection, bool) {
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	collection, err := app.collections(r).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return collection, true
+}
+
+// The writeCollectionResponse() helper sends a collection along with its movies.
+func (app *application) writeCollectionResponse(w http.ResponseWriter, r *http.Request, status int, collection *data.Collection, headers http.Header) {
+
+	movies, err := app.collections(r).GetMovies(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	collection.Movies = movies
+
+	err = app.writeJSON(w, status, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The collectionAuditRecord struct is what we record in the audit log for changes to a
+// collection: its fields plus the IDs of its movies, in order, rather than the movies
+// themselves.
+type collectionAuditRecord struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	MovieIDs    []int64 `json:"movie_ids"`
+}
+
+func collectionAudit(collection *data.Collection, movieIDs []int64) collectionAuditRecord {
+	return collectionAuditRecord{Name: collection.Name, Description: collection.Description, MovieIDs: movieIDs}
+}
+
+// The movieIDs() helper returns the IDs of the given movies.
+func movieIDs(movies []*data.Movie) []int64 {
+
+	ids := make([]int64, len(movies))
+
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	return ids
+}
@@ -15,6 +15,29 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// Likewise, we use the tokenContextKey constant to store the SHA-256 hash of the token
+// that the request was authenticated with. We store the hash rather than the plaintext,
+// because that's all that we know for signed tokens.
+const tokenContextKey = contextKey("token")
+
+// When a request is authenticated with a signed token, the user's permissions are
+// carried in the token itself, and we store them in the request context using the
+// permissionsContextKey constant.
+const permissionsContextKey = contextKey("permissions")
+
+// When a request is authenticated with a personal access token, we store the permission
+// scopes that the token is restricted to in the request context using the
+// tokenScopesContextKey constant.
+const tokenScopesContextKey = contextKey("tokenScopes")
+
+// The ID of the organization that the user is working in is stored in the request
+// context using the organizationContextKey constant.
+const organizationContextKey = contextKey("organization")
+
+// Every request is given an ID by the requestID() middleware, which we store in the
+// request context using the requestIDContextKey constant.
+const requestIDContextKey = contextKey("requestID")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -37,3 +60,95 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// The contextSetTokenHash() method returns a new copy of the request with the hash of
+// the authentication token added to the context.
+func (app *application) contextSetTokenHash(r *http.Request, tokenHash []byte) *http.Request {
+
+	ctx := context.WithValue(r.Context(), tokenContextKey, tokenHash)
+	return r.WithContext(ctx)
+}
+
+// The contextGetTokenHash() method retrieves the hash of the authentication token from
+// the request context. Like contextGetUser() we should only call this in handlers which
+// are protected by the requireAuthenticatedUser() middleware, so a missing token is an
+// 'unexpected' error.
+func (app *application) contextGetTokenHash(r *http.Request) []byte {
+
+	tokenHash, ok := r.Context().Value(tokenContextKey).([]byte)
+	if !ok {
+		panic("missing token in request context")
+	}
+
+	return tokenHash
+}
+
+// The contextSetPermissions() method returns a new copy of the request with the provided
+// Permissions slice added to the context.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The contextGetPermissions() method retrieves the Permissions slice from the request
+// context. Unlike the other helpers, it's normal for there to be no permissions in the
+// context (because the request was authenticated with a database-backed token), so we
+// return a boolean to indicate whether they were found instead of panicking.
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+// The contextSetTokenScopes() method returns a new copy of the request with the
+// permission scopes of a personal access token added to the context.
+func (app *application) contextSetTokenScopes(r *http.Request, scopes data.Permissions) *http.Request {
+
+	ctx := context.WithValue(r.Context(), tokenScopesContextKey, scopes)
+	return r.WithContext(ctx)
+}
+
+// The contextGetTokenScopes() method retrieves the permission scopes of a personal
+// access token from the request context. The boolean return value is false if the
+// request wasn't authenticated with a personal access token.
+func (app *application) contextGetTokenScopes(r *http.Request) (data.Permissions, bool) {
+
+	scopes, ok := r.Context().Value(tokenScopesContextKey).(data.Permissions)
+	return scopes, ok
+}
+
+// The contextSetRequestID() method returns a new copy of the request with the request
+// ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method retrieves the request ID from the request context.
+// It returns an empty string if there isn't one, which is only the case for requests
+// that haven't been through the requestID() middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// The contextSetOrganization() method returns a new copy of the request with the ID of
+// the organization that the user is working in added to the context.
+func (app *application) contextSetOrganization(r *http.Request, organizationID int64) *http.Request {
+
+	ctx := context.WithValue(r.Context(), organizationContextKey, organizationID)
+	return r.WithContext(ctx)
+}
+
+// The contextGetOrganization() method retrieves the ID of the organization that the user
+// is working in from the request context. It returns zero if the user isn't working in
+// any organization (including when the request is anonymous), and because no
+// organization has the ID zero, models scoped to it will never find anything.
+func (app *application) contextGetOrganization(r *http.Request) int64 {
+
+	organizationID, _ := r.Context().Value(organizationContextKey).(int64)
+	return organizationID
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // The logError() method is a generic helper for logging an error message along
@@ -95,6 +98,31 @@ func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *htt
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// .
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// .
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "your user account has been temporarily locked due to too many failed login attempts, please try again later or reset your password"
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
+
+// .
+func (app *application) twoFactorRequiredResponse(w http.ResponseWriter, r *http.Request) {
+
+	message := "a two-factor authentication code is required"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 // .
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 
@@ -104,6 +132,13 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// .
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+
+	message := "invalid or expired refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 // .
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 
@@ -123,3 +158,27 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resources"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// The impersonationNotAllowedResponse() method is used when somebody who is impersonating
+// a user tries to use an endpoint which is blocked during impersonation.
+func (app *application) impersonationNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+
+	message := "this action is not allowed while impersonating another user"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The noOrganizationResponse() method is used when a user tries to create something
+// which belongs to an organization while they aren't working in any organization.
+func (app *application) noOrganizationResponse(w http.ResponseWriter, r *http.Request) {
+
+	message := "you must be a member of an organization to perform this action"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The requestTooLargeResponse() method is used when an upload is larger than the limit
+// for the endpoint.
+func (app *application) requestTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+
+	message := fmt.Sprintf("the request body must not be larger than %d bytes", limit)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
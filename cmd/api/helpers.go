@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -18,17 +19,37 @@ import (
 // Retrieve the "id" URL parameter from the current request context, then convert it to
 // an integer and return it. If the operation isn't successful, return 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// The readNamedIDParam() helper is like readIDParam(), but reads the URL parameter with
+// the given name. We use it for routes with more than one ID, like
+// /v1/movies/:id/reviews/:review_id.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
+// The clientIP() helper returns the IP address of the client that made the request,
+// falling back to the full remote address if it isn't in the expected "host:port"
+// format.
+func (app *application) clientIP(r *http.Request) string {
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
 type envelope map[string]any
 
 // Define a writeJSON() helper for sending responses. This takes the destination
@@ -220,6 +241,28 @@ func (app *application) readInt(qs url.Values, key string, defaultValues int, v
 	return i
 }
 
+// The readBool() helper reads a string value from the query string and converts it to
+// a bool. If no matching key could be found it returns nil, so that the caller can tell
+// the difference between the value being false and not being provided at all. If the
+// value couldn't be converted to a bool, then we record an error message in the
+// provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
 // The background() helper accepts an arbitrary function as a parameter.
 func (app *application) background(fn func()) {
 
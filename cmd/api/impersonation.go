@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/high-la/greenlight/internal/data"
+)
+
+// Issue a short-lived authentication token which lets a member of staff act as another
+// user, so that they can see exactly what the user sees. The token records who the
+// member of staff is, every response to a request made with it carries the
+// X-Impersonated-By header, and everything done with it is recorded in the audit log
+// under both identities. Endpoints which manage the user's credentials and account are
+// blocked by the denyImpersonation() middleware.
+func (app *application) impersonateUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := app.readNamedIDParam(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+
+	if userID == actor.ID {
+		app.badRequestResponse(w, r, errors.New("you can't impersonate yourself"))
+		return
+	}
+
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Don't let staff use impersonation to get permissions that they don't have
+	// themselves, by impersonating an administrator for example.
+	actorPermissions, err := app.userPermissions(r, actor)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	userPermissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range data.PermissionCatalog {
+		if userPermissions.Include(code) && !actorPermissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	// The token works in the first organization that the user joined, in the same way
+	// as when they log in themselves.
+	organizations, err := app.models.Organizations.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var organizationID int64
+	if len(organizations) > 0 {
+		organizationID = organizations[0].ID
+	}
+
+	token, err := app.models.Tokens.NewImpersonation(user.ID, actor.ID, organizationID, app.config.auth.impersonationTTL, app.clientIP(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.ImpersonatorID = actor.ID
+
+	err = app.signAuthenticationToken(token, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, actor, "user.impersonate", "user", user.ID, nil, auditToken(token))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// List the current user's lists in the organization that they're working in. The
+// movies in each list aren't included; clients fetch a single list to get them.
+func (app *application) listCurrentUserListsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "created_at", "updated_at", "-id", "-name", "-created_at", "-updated_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	lists, metadata, err := app.lists(r).GetAllForUser(user.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"lists": lists, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create a new, empty list. Watchlists and favorites lists get a default name if the
+// client doesn't give one, and every list gets a generated slug unless the client
+// chooses its own.
+func (app *application) createListHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Kind   string `json:"kind"`
+		Name   string `json:"name"`
+		Slug   string `json:"slug"`
+		Public bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	list := &data.List{
+		UserID: user.ID,
+		Kind:   input.Kind,
+		Name:   input.Name,
+		Slug:   input.Slug,
+		Public: input.Public,
+	}
+
+	if list.Kind == "" {
+		list.Kind = "custom"
+	}
+
+	if list.Name == "" {
+		list.Name = data.DefaultListName(list.Kind)
+	}
+
+	if list.Slug == "" {
+		list.Slug, err = data.GenerateListSlug(list.Name)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.lists(r).Insert(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a list with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateListKind):
+			v.AddError("kind", fmt.Sprintf("you already have a %s list", list.Kind))
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrNoOrganization):
+			app.noOrganizationResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, user, "list.create", "list", list.ID, nil, list)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/lists/%d", list.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"list": list}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Show one of the current user's lists, along with its movies.
+func (app *application) showCurrentUserListHandler(w http.ResponseWriter, r *http.Request) {
+
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeListResponse(w, r, list)
+}
+
+// Show a list, using its slug. Public lists can be shared by link with the other
+// members of the list's organization, but not with anybody outside it, because the
+// movies in a list are part of the organization's private catalog. Private lists can
+// only be seen by their owner. Either way, a list that the user isn't allowed to see
+// looks like it doesn't exist.
+func (app *application) showPublicListHandler(w http.ResponseWriter, r *http.Request) {
+
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	list, err := app.models.Lists.GetBySlug(slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if list.UserID != user.ID {
+		if !list.Public {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		member, err := app.models.Organizations.IsMember(list.OrganizationID, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !member {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	app.writeListResponse(w, r, list)
+}
+
+// Rename a list, change its slug, or make it public or private.
+func (app *application) updateListHandler(w http.ResponseWriter, r *http.Request) {
+
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+
+	before := *list
+
+	var input struct {
+		Name   *string `json:"name"`
+		Slug   *string `json:"slug"`
+		Public *bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		list.Name = *input.Name
+	}
+	if input.Slug != nil {
+		list.Slug = *input.Slug
+	}
+	if input.Public != nil {
+		list.Public = *input.Public
+	}
+
+	v := validator.New()
+
+	if data.ValidateList(v, list); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.lists(r).Update(list)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "a list with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.contextGetUser(r), "list.update", "list", list.ID, &before, list)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete a list. The movies themselves aren't affected.
+func (app *application) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.lists(r).Delete(list.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.contextGetUser(r), "list.delete", "list", list.ID, list, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "list successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a movie to the end of a list, and respond with the updated list.
+func (app *application) addListItemHandler(w http.ResponseWriter, r *http.Request) {
+
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.MovieID > 0, "movie_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.lists(r).AddMovie(list.ID, input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must be an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateListItem):
+			v.AddError("movie_id", "this movie is already in the list")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reloadListResponse(w, r, list.ID)
+}
+
+// Put the movies in a list into a new order. The client must send the IDs of all of
+// the movies in the list, each exactly once, in the order that they want.
+func (app *application) reorderListItemsHandler(w http.ResponseWriter, r *http.Request) {
+
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(validator.Unique(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.lists(r).Reorder(list.ID, input.MovieIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrListOrderMismatch):
+			v.AddError("movie_ids", "must contain each movie in the list exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reloadListResponse(w, r, list.ID)
+}
+
+// Remove a movie from a list, and respond with the updated list.
+func (app *application) removeListItemHandler(w http.ResponseWriter, r *http.Request) {
+
+	list, ok := app.readListParam(w, r)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.lists(r).RemoveMovie(list.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reloadListResponse(w, r, list.ID)
+}
+
+// The lists() helper returns a ListModel for the organization that the user is working
+// in, in the same way as the movies() helper.
+func (app *application) lists(r *http.Request) data.ListModel {
+	return app.models.Lists.ForOrganization(app.contextGetOrganization(r))
+}
+
+// The readListParam() helper fetches the list whose ID is in the URL and checks that
+// it belongs to the current user. We send a 404 Not Found response for other users'
+// lists, rather than 403 Forbidden, so that clients can't find out which private lists
+// exist.
+func (app *application) readListParam(w http.ResponseWriter, r *http.Request) (*data.List, bool) {
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	list, err := app.lists(r).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if list.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return list, true
+}
+
+// The reloadListResponse() helper fetches a list again after its items have changed,
+// so that the response includes the new items count and update time.
+func (app *application) reloadListResponse(w http.ResponseWriter, r *http.Request, id int64) {
+
+	list, err := app.lists(r).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeListResponse(w, r, list)
+}
+
+// The writeListResponse() helper sends a list along with its movies.
+func (app *application) writeListResponse(w http.ResponseWriter, r *http.Request, list *data.List) {
+
+	movies, err := app.models.Lists.GetMovies(list.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	list.Movies = movies
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"list": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
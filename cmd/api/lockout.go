@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/high-la/greenlight/internal/data"
+)
+
+// The accountLoginWait() helper returns the lockout record for an email address (which
+// is nil if there have been no recent failed login attempts for it), along with how long
+// the client has to wait before they may try to log in again. The returned bool is true
+// if the wait is because the account is locked, rather than because of the backoff
+// delay. This works the same whether or not the email address belongs to an account.
+func (app *application) accountLoginWait(email string) (*data.Lockout, time.Duration, bool, error) {
+
+	lockout, err := app.models.Lockouts.GetForEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, 0, false, nil
+		default:
+			return nil, 0, false, err
+		}
+	}
+
+	if !app.config.lockout.enabled {
+		return lockout, 0, false, nil
+	}
+
+	now := time.Now()
+
+	if lockout.IsLocked(now) {
+		return lockout, lockout.LockedUntil.Sub(now), true, nil
+	}
+
+	until := lockout.LastFailedAt.Add(backoff(lockout.FailedAttempts, app.config.lockout.baseDelay, app.config.lockout.maxDelay))
+
+	return lockout, max(until.Sub(now), 0), false, nil
+}
+
+// The checkLoginWait() helper checks that the client's IP address hasn't failed to log
+// in too many times recently, and that logins for the email address aren't locked or
+// backing off, and sends the appropriate response if they are. We use it to protect
+// everything which checks a password or a two-factor authentication code, not just the
+// login endpoints, so that a stolen session can't be used to guess them either. It
+// returns the lockout record for the email address (which is nil if there isn't one),
+// and false if the request shouldn't go ahead.
+func (app *application) checkLoginWait(w http.ResponseWriter, r *http.Request, email string) (*data.Lockout, bool) {
+
+	if wait := app.loginThrottle.wait(app.clientIP(r)); wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return nil, false
+	}
+
+	lockout, wait, locked, err := app.accountLoginWait(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if locked {
+		app.accountLockedResponse(w, r, wait)
+		return nil, false
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return nil, false
+	}
+
+	return lockout, true
+}
+
+// The recordLoginFailure() helper records a failed login attempt against the client's
+// IP address and, if we know which email address they were trying to log in with,
+// against the email address too. The user is nil if the email address doesn't belong
+// to an account. If this failure causes a user's account to be locked, then we send
+// them an email to let them know.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User) error {
+
+	if !app.config.lockout.enabled {
+		return nil
+	}
+
+	ip := app.clientIP(r)
+
+	app.loginThrottle.fail(ip)
+
+	if email == "" {
+		return nil
+	}
+
+	lockout, locked, err := app.models.Lockouts.RecordFailure(email, app.config.lockout.maxAttempts, app.config.lockout.window, app.config.lockout.duration)
+	if err != nil {
+		return err
+	}
+
+	if locked && user != nil {
+		app.logger.Warn("account locked", "user_id", user.ID, "ip", ip)
+
+		app.background(func() {
+
+			data := map[string]any{
+				"lockedUntil": lockout.LockedUntil.UTC().Format(time.RFC1123),
+				"ipAddress":   ip,
+			}
+
+			err := app.mailer.Send(user.Email, "account_locked.tmpl.html", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// Generate a single-use login token and email it to the user, so that they can log in
+// without a password.
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the email limiter, in the same way as when re-sending activation emails.
+	if !app.emailLimiter.allow(input.Email) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// Try to retrieve the corresponding user record for the email address. As with
+	// password resets, we send the same response whether or not it exists.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil {
+
+		// Delete any login tokens that were issued previously, so that only the link
+		// in the newest email can be used.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeLogin, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Create a new login token with a 15-minute expiry time. Anyone who gets hold
+		// of the email can log in as the user, so we keep it short.
+		token, err := app.models.Tokens.New(user.ID, 15*time.Minute, data.ScopeLogin)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Email the user with their login token, and the link to our frontend if one
+		// has been configured.
+		app.background(func() {
+			data := map[string]any{
+				"loginToken": token.Plaintext,
+			}
+
+			if app.config.auth.magicLinkURL != "" {
+				data["magicLinkURL"] = app.config.auth.magicLinkURL + "?token=" + url.QueryEscape(token.Plaintext)
+			}
+
+			err := app.mailer.Send(user.Email, "token_magic_link.tmpl.html", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	// Send a 202 Accepted response and confirmation message to the client.
+	env := envelope{"message": "if an account exists for this email address, you will receive an email containing a login link"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Exchange a login token from a magic link email for a new session.
+func (app *application) exchangeMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the login token from the request body, along with the optional two-factor
+	// authentication code. Users who have enabled two-factor authentication still need
+	// to provide a code, because the magic link only proves that they can read their
+	// email.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		TOTPCode       string `json:"totp_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Like the createAuthenticationTokenHandler, check that the client's IP address
+	// hasn't failed to log in too many times recently before doing anything else.
+	if wait := app.loginThrottle.wait(app.clientIP(r)); wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
+	// Retrieve the details of the user associated with the login token.
+	user, err := app.models.Users.GetForToken(data.ScopeLogin, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.recordLoginFailure(r, "", nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			v.AddError("token", "invalid or expired login token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The magic link is only one factor, so a locked account stays locked, and the
+	// client must wait after a failed attempt before they can try another two-factor
+	// authentication code.
+	lockout, wait, locked, err := app.accountLoginWait(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if locked {
+		app.accountLockedResponse(w, r, wait)
+		return
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
+	// Check the two-factor authentication code before we use up the login token, so
+	// that the client can retry with the same token if the user needs to enter a code.
+	if !app.checkSecondFactor(w, r, user, input.TOTPCode) {
+		return
+	}
+
+	// The login was successful, so clear any failed attempts for the account.
+	if lockout != nil {
+		err = app.models.Lockouts.DeleteForEmail(user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Delete all login tokens for the user, so that the link can't be used again.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeLogin, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The user has proven that they own the email address, so if their account hasn't
+	// been activated yet then we activate it now, in the same way as the
+	// activateUserHandler does.
+	if !user.Activated {
+		before := *user
+		user.Activated = true
+
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.audit(r, user, "user.activate", "user", user.ID, &before, user)
+	}
+
+	app.startSession(w, r, user)
+}
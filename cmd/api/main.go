@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -16,7 +18,9 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/jwt"
 	"github.com/high-la/greenlight/internal/mailer"
+	"github.com/high-la/greenlight/internal/storage"
 	"github.com/high-la/greenlight/internal/vcs"
 	_ "github.com/lib/pq"
 )
@@ -58,6 +62,13 @@ type config struct {
 		enabled bool
 	}
 
+	// Add an emailLimiter struct containing the maximum number of emails we will send
+	// to a single email address per hour, and a boolean field to enable/disable it.
+	emailLimiter struct {
+		perHour int
+		enabled bool
+	}
+
 	// .
 	smtp struct {
 		host     string
@@ -71,6 +82,77 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+
+	// Add a lockout struct holding the settings for slowing down and locking out
+	// clients which repeatedly fail to log in. Accounts are locked for duration after
+	// maxAttempts failures, and IP addresses are blocked after ipMaxAttempts failures.
+	// Failures older than window are forgotten, and the delay between attempts starts
+	// at baseDelay and doubles with each failure up to maxDelay.
+	lockout struct {
+		maxAttempts   int
+		ipMaxAttempts int
+		duration      time.Duration
+		window        time.Duration
+		baseDelay     time.Duration
+		maxDelay      time.Duration
+		enabled       bool
+	}
+
+	// Add a permissionsCache struct holding how long users' permissions are cached for.
+	permissionsCache struct {
+		ttl time.Duration
+	}
+
+	// Add a registration struct holding the name of the role that new users are given
+	// when they register.
+	registration struct {
+		defaultRole         string
+		defaultOrganization string
+	}
+
+	// Add an accounts struct holding how long soft deleted accounts are kept before
+	// they are purged, and how often we check for accounts to purge.
+	accounts struct {
+		deletionGracePeriod time.Duration
+		purgeInterval       time.Duration
+	}
+
+	// Add an auth struct holding the settings for the tokens that we issue.
+	auth struct {
+		// The lifetimes of the authentication (access) tokens and refresh tokens that
+		// we issue when a user logs in.
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+
+		// The tokenMode field controls whether we issue database-backed authentication
+		// tokens ("database") or signed tokens which can be verified without a database
+		// lookup ("signed").
+		tokenMode string
+
+		// The signingKeys field holds the keys used to sign authentication tokens in
+		// signed mode.
+		signingKeys string
+
+		// The revocationSyncInterval field controls how often we refresh the in-memory
+		// revocation list in signed mode.
+		revocationSyncInterval time.Duration
+
+		// The magicLinkURL field holds the address of the page in our frontend which
+		// exchanges magic login links; if it's set, the login token is appended to it as
+		// a query string parameter in the email we send.
+		magicLinkURL string
+
+		// The impersonationTTL field holds the lifetime of the tokens that we issue to
+		// support staff who are impersonating a user.
+		impersonationTTL time.Duration
+	}
+
+	// Add an uploads struct holding the directory that uploaded files are kept in, and
+	// the largest poster upload that we accept, in bytes.
+	uploads struct {
+		dir            string
+		posterMaxBytes int64
+	}
 }
 
 // Define application struct to hold the dependencies for HTTP handlers, helpers,
@@ -80,11 +162,17 @@ type config struct {
 // sync.WaitGroup type is a valid, useable, sync.WaitGroup with a 'counter' value of 0,
 // so we don't need to do anything else to initialize it before we can use it.
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config         config
+	logger         *slog.Logger
+	models         data.Models
+	mailer         mailer.Mailer
+	emailLimiter   *emailLimiter
+	loginThrottle  *loginThrottle
+	sessionToucher *sessionToucher
+	signer         *jwt.Signer
+	revocations    *revocationList
+	blobs          storage.BlobStore
+	wg             sync.WaitGroup
 }
 
 func main() {
@@ -130,6 +218,31 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	// Read the email limiter settings into the config struct.
+	flag.IntVar(&cfg.emailLimiter.perHour, "email-limiter-per-hour", 3, "Email limiter maximum emails per address per hour")
+	flag.BoolVar(&cfg.emailLimiter.enabled, "email-limiter-enabled", true, "Enable email limiter")
+
+	// Read the login lockout settings into the config struct.
+	flag.IntVar(&cfg.lockout.maxAttempts, "lockout-max-attempts", 10, "Failed logins before an account is locked")
+	flag.IntVar(&cfg.lockout.ipMaxAttempts, "lockout-ip-max-attempts", 50, "Failed logins before an IP address is blocked")
+	flag.DurationVar(&cfg.lockout.duration, "lockout-duration", 15*time.Minute, "Account lock duration")
+	flag.DurationVar(&cfg.lockout.window, "lockout-window", time.Hour, "How long failed logins are remembered")
+	flag.DurationVar(&cfg.lockout.baseDelay, "lockout-base-delay", time.Second, "Delay after the first failed login")
+	flag.DurationVar(&cfg.lockout.maxDelay, "lockout-max-delay", 30*time.Second, "Maximum delay between failed logins")
+	flag.BoolVar(&cfg.lockout.enabled, "lockout-enabled", true, "Enable login lockouts")
+
+	// Read the permissions cache TTL into the config struct. A TTL of zero disables the
+	// cache.
+	flag.DurationVar(&cfg.permissionsCache.ttl, "permissions-cache-ttl", time.Minute, "Permissions cache TTL (0 to disable)")
+
+	// Read the default role for new users into the config struct.
+	flag.StringVar(&cfg.registration.defaultRole, "registration-default-role", "viewer", "Role given to newly registered users")
+	flag.StringVar(&cfg.registration.defaultOrganization, "registration-default-organization", "default", "Slug of the organization that newly registered users join (empty for none)")
+
+	// Read the account deletion settings into the config struct.
+	flag.DurationVar(&cfg.accounts.deletionGracePeriod, "accounts-deletion-grace-period", 30*24*time.Hour, "How long deleted accounts are kept before they are purged")
+	flag.DurationVar(&cfg.accounts.purgeInterval, "accounts-purge-interval", time.Hour, "How often to purge deleted accounts")
+
 	// Use the value of the GREENLIGHT_DB_DSN environment var as the default value
 	// for our db-dsn command line flag.
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
@@ -161,6 +274,28 @@ func main() {
 		return nil
 	})
 
+	// Read the token lifetimes into the config struct. Authentication tokens are short
+	// lived, and clients use their refresh token to get a new one when it expires.
+	flag.DurationVar(&cfg.auth.accessTokenTTL, "auth-access-token-ttl", 15*time.Minute, "Authentication token lifetime")
+	flag.DurationVar(&cfg.auth.refreshTokenTTL, "auth-refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+
+	// Read the signed token settings into the config struct. The signing keys are given
+	// as a space separated list of "<key id>:<base64 encoded key>" pairs, and the first
+	// key in the list is used to sign new tokens.
+	flag.StringVar(&cfg.auth.tokenMode, "auth-token-mode", "database", "Authentication token mode (database|signed)")
+	flag.StringVar(&cfg.auth.signingKeys, "auth-signing-keys", os.Getenv("GREENLIGHT_AUTH_SIGNING_KEYS"), "Authentication token signing keys (space separated kid:base64key pairs)")
+	flag.DurationVar(&cfg.auth.revocationSyncInterval, "auth-revocation-sync-interval", 15*time.Second, "Token revocation list sync interval")
+
+	// Read the base URL for magic login links into the config struct.
+	flag.StringVar(&cfg.auth.magicLinkURL, "auth-magic-link-url", "", "Base URL for magic login links")
+
+	// Read the lifetime of impersonation tokens into the config struct.
+	flag.DurationVar(&cfg.auth.impersonationTTL, "auth-impersonation-ttl", 15*time.Minute, "Impersonation token lifetime")
+
+	// Read the upload settings into the config struct.
+	flag.StringVar(&cfg.uploads.dir, "uploads-dir", "./uploads", "Directory for uploaded files")
+	flag.Int64Var(&cfg.uploads.posterMaxBytes, "uploads-poster-max-bytes", 10*1024*1024, "Maximum poster upload size in bytes")
+
 	// Create a new version boolean flag with the default value of false.
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -174,6 +309,21 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Call the newSigner() helper function (see below) to create the signer for signed
+	// authentication tokens. This returns nil if no signing keys are configured.
+	signer, err := newSigner(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Open the blob store that uploaded posters are kept in.
+	blobs, err := storage.NewFileSystem(cfg.uploads.dir)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	// Call the openDB() helper function(see below) to create the connection pool,
 	// passing in the config struct. If this returns an error, we log it and exit the
 	// application immediately.
@@ -218,12 +368,87 @@ func main() {
 	// Initialize a new Mailer instance using the settings from the command line
 	// flags, and add it to the application struct
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:         cfg,
+		logger:         logger,
+		models:         data.NewModels(db, cfg.permissionsCache.ttl),
+		mailer:         mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		emailLimiter:   newEmailLimiter(cfg.emailLimiter.perHour, cfg.emailLimiter.enabled),
+		loginThrottle:  newLoginThrottle(cfg.lockout.ipMaxAttempts, cfg.lockout.baseDelay, cfg.lockout.maxDelay, cfg.lockout.window, cfg.lockout.enabled),
+		sessionToucher: newSessionToucher(),
+		signer:         signer,
+		revocations:    newRevocationList(),
+		blobs:          blobs,
 	}
 
+	// Check that the permissions table matches the permission codes that the
+	// application knows about.
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	err = data.ValidatePermissionCatalog(permissions)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Check that the default role for new users exists, so that we find out about a
+	// typo now rather than when the first user registers.
+	if cfg.registration.defaultRole != "" {
+		exists, err := app.models.Roles.Exists(cfg.registration.defaultRole)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		if !exists {
+			logger.Error("registration default role does not exist", "role", cfg.registration.defaultRole)
+			os.Exit(1)
+		}
+	}
+
+	// Likewise, check that the default organization for new users exists.
+	if cfg.registration.defaultOrganization != "" {
+		_, err := app.models.Organizations.GetBySlug(cfg.registration.defaultOrganization)
+		if err != nil {
+			logger.Error("registration default organization could not be loaded", "organization", cfg.registration.defaultOrganization, "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// If the permissions cache is enabled, publish its hit and miss counters and start
+	// listening for changes to users' permissions.
+	if app.models.Permissions.Cache != nil {
+		expvar.Publish("permissions_cache", expvar.Func(func() any {
+			hits, misses := app.models.Permissions.Cache.Stats()
+			return map[string]int64{"hits": hits, "misses": misses}
+		}))
+
+		err = app.listenForPermissionChanges()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// If we might receive signed authentication tokens, load the revocation list before
+	// we start serving requests and then keep it up to date in the background.
+	if app.signer != nil {
+		err = app.syncRevocations()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		app.startRevocationSync()
+	}
+
+	// Start the background job which purges deleted accounts once their grace period
+	// has passed.
+	app.startAccountPurge()
+
 	// Call app.serve() to start the server
 	err = app.serve()
 	if err != nil {
@@ -274,3 +499,47 @@ func openDB(cfg config) (*sql.DB, error) {
 	// Return the sql.DB connection pool.
 	return db, nil
 }
+
+// The newSigner() function returns a jwt.Signer using the signing keys from the config
+// struct, or nil if there are none. It returns an error if the keys are malformed, or if
+// signed tokens are enabled without any keys.
+func newSigner(cfg config) (*jwt.Signer, error) {
+
+	switch cfg.auth.tokenMode {
+	case "database", "signed":
+	default:
+		return nil, fmt.Errorf("invalid auth-token-mode %q", cfg.auth.tokenMode)
+	}
+
+	pairs := strings.Fields(cfg.auth.signingKeys)
+
+	if len(pairs) == 0 {
+		if cfg.auth.tokenMode == "signed" {
+			return nil, errors.New("auth-signing-keys must be set when auth-token-mode is signed")
+		}
+		return nil, nil
+	}
+
+	keys := make(map[string][]byte)
+
+	for i, pair := range pairs {
+		// Note that we refer to malformed keys by their position in the list, so that
+		// we never write key material to the logs.
+		id, encodedKey, ok := strings.Cut(pair, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid signing key #%d: must be in the format kid:base64key", i+1)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %q: %w", id, err)
+		}
+
+		keys[id] = key
+	}
+
+	// The first key in the list is the active key.
+	activeKeyID, _, _ := strings.Cut(pairs[0], ":")
+
+	return jwt.New(activeKeyID, keys)
+}
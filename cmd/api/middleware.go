@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
@@ -12,6 +15,7 @@ import (
 	"time"
 
 	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/jwt"
 	"github.com/high-la/greenlight/internal/validator"
 	"golang.org/x/time/rate"
 )
@@ -44,6 +48,49 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// The requestID() middleware gives every request an ID, which is sent back to the client
+// in the X-Request-ID header and recorded in the audit log. If the request already has a
+// sensible X-Request-ID header (for example, because it was set by a load balancer) we
+// use that, so that the ID can be followed from one system to the next.
+func (app *application) requestID(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// The validRequestID() function reports whether a request ID sent by the client is safe
+// to use: it must be no more than 64 characters long, and only contain letters, digits,
+// dashes, underscores and dots.
+func validRequestID(id string) bool {
+
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
 // .
 func (app *application) rateLimit(next http.Handler) http.Handler {
 
@@ -172,6 +219,19 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication token from the header parts
 		token := headerParts[1]
 
+		// If the token is a signed token, then we authenticate the request using the
+		// claims in the token instead of looking up the user in the database.
+		if app.signer != nil && jwt.IsSigned(token) {
+			app.authenticateSigned(w, r, next, token)
+			return
+		}
+
+		// Likewise, personal access tokens are recognised by their prefix.
+		if strings.HasPrefix(token, data.PersonalAccessTokenPrefix) {
+			app.authenticatePersonalAccess(w, r, next, token)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format
 		v := validator.New()
 
@@ -184,10 +244,9 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Retrieve the details of the user associated with the authentication token,
-		// again calling the invalidAuthenticationTokenResponse() helper if no
-		// matching record was found. IMPORTANT: Notice that we are using
-		// ScopeAuthentication as the first parameter here.
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		// along with the organization that they are working in, again calling the
+		// invalidAuthenticationTokenResponse() helper if no matching record was found.
+		user, organizationID, err := app.models.Users.GetForAuthenticationToken(token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -198,15 +257,138 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		app.markImpersonation(w, user)
+
 		// Call the contextSetUser() helper to add the user information to the request
-		// context.
+		// context, and the contextSetTokenHash() helper to record which token was used,
+		// so that it can be revoked when the user logs out.
+		tokenHash := sha256.Sum256([]byte(token))
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetTokenHash(r, tokenHash[:])
+		r = app.contextSetOrganization(r, organizationID)
+
+		// Record the last use of the token.
+		app.touchSession(r, tokenHash[:])
 
 		// call the next handler in the chain
 		next.ServeHTTP(w, r)
 	})
 }
 
+// The authenticateSigned() method is used by the authenticate() middleware to handle
+// requests which carry a signed authentication token. The token's signature, expiry
+// time and revocation status are all checked in memory, so no database queries are
+// needed to authenticate the request or to check the user's permissions.
+func (app *application) authenticateSigned(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+
+	// Verify the token's signature and expiry time.
+	claims, err := app.signer.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// The jti claim of a signed token holds the hex-encoded hash of the database token
+	// which was created alongside it (not its plaintext, because anybody who can see
+	// a signed token can read its claims). If that database token has been deleted
+	// (because the user logged out, for example), then its hash will be in the
+	// revocation list.
+	tokenHash, err := hex.DecodeString(claims.ID)
+	if err != nil || len(tokenHash) != sha256.Size {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	if app.revocations.contains(tokenHash) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// Build a User struct from the claims. Note that this only contains the user's ID
+	// and activation status; handlers which need the rest of the user's details must
+	// fetch them from the database.
+	user := &data.User{
+		ID:             claims.UserID,
+		Activated:      claims.Activated,
+		ImpersonatorID: claims.ImpersonatorID,
+	}
+
+	app.markImpersonation(w, user)
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetTokenHash(r, tokenHash)
+	r = app.contextSetPermissions(r, data.Permissions(claims.Permissions))
+	r = app.contextSetOrganization(r, claims.OrganizationID)
+
+	app.touchSession(r, tokenHash)
+
+	next.ServeHTTP(w, r)
+}
+
+// The authenticatePersonalAccess() method is used by the authenticate() middleware to
+// handle requests which carry a personal access token.
+func (app *application) authenticatePersonalAccess(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+
+	// Check that the token is a sensible length before we go to the database.
+	if len(token) != len(data.PersonalAccessTokenPrefix)+26 {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, scopes, organizationID, err := app.models.Users.GetForPersonalAccessToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetTokenHash(r, tokenHash[:])
+	r = app.contextSetTokenScopes(r, scopes)
+	r = app.contextSetOrganization(r, organizationID)
+
+	app.touchSession(r, tokenHash[:])
+
+	next.ServeHTTP(w, r)
+}
+
+// The markImpersonation() helper adds the X-Impersonated-By header to the response if
+// the request was made by somebody impersonating the user, so that clients can make it
+// obvious that the session isn't the user's own.
+func (app *application) markImpersonation(w http.ResponseWriter, user *data.User) {
+
+	if user.ImpersonatorID != 0 {
+		w.Header().Set("X-Impersonated-By", strconv.FormatInt(user.ImpersonatorID, 10))
+	}
+}
+
+// The touchSession() helper records the last use of an authentication token, along with
+// the client's IP address and User-Agent. The sessionToucher makes sure we only do this
+// at most once a minute for each token, and we do it in the background so that it
+// doesn't slow down the response.
+func (app *application) touchSession(r *http.Request, tokenHash []byte) {
+
+	if !app.sessionToucher.shouldTouch(tokenHash) {
+		return
+	}
+
+	ip, userAgent := app.clientIP(r), r.UserAgent()
+
+	app.background(func() {
+		err := app.models.Tokens.Touch(tokenHash, ip, userAgent)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
 // Create a new requireAuthenticatedUser() middleware to check that a user is not
 // anonymous.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
@@ -239,6 +421,39 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// The denyPersonalAccessTokens() middleware rejects requests which were authenticated
+// with a personal access token. We use it to protect endpoints which manage a user's
+// credentials, so that a leaked token can't be used to take over the account.
+func (app *application) denyPersonalAccessTokens(next http.HandlerFunc) http.HandlerFunc {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if _, ok := app.contextGetTokenScopes(r); ok {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The denyImpersonation() middleware rejects requests made by somebody who is
+// impersonating the user. We use it to protect endpoints which manage the user's
+// credentials and account, which support staff should never need to use on a user's
+// behalf.
+func (app *application) denyImpersonation(next http.HandlerFunc) http.HandlerFunc {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if app.contextGetUser(r).ImpersonatorID != 0 {
+			app.impersonationNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Note that the first parameter for the middleware function is the permission code that
 // we require the user to have.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
@@ -249,7 +464,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		user := app.contextGetUser(r)
 
 		// Get the slice of permissions for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.userPermissions(r, user)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -271,6 +486,30 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// The userPermissions() helper returns the permissions for the user who made the
+// request. If the request was authenticated with a signed token then the permissions
+// are already in the request context; otherwise we fetch them from the database. If the
+// request was authenticated with a personal access token, then we only return the
+// permissions which are in both the user's permissions and the token's scopes.
+func (app *application) userPermissions(r *http.Request, user *data.User) (data.Permissions, error) {
+
+	permissions, ok := app.contextGetPermissions(r)
+	if !ok {
+		var err error
+
+		permissions, err = app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if scopes, ok := app.contextGetTokenScopes(r); ok {
+		permissions = permissions.Intersect(scopes)
+	}
+
+	return permissions, nil
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,11 +35,13 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Copy the values from the input struct to a new Moview struct.
 
 	// Note that the movie var contains a *pointer* to a Movie struct.
+	// We also record the ID of the user who is creating the movie.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		CreatedBy: &app.contextGetUser(r).ID,
 	}
 
 	// Initialize a new Validator instance.
@@ -55,12 +57,19 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Call the Insert method on our movies model, passing in a pointer to the
 	// validated movie struct. This will create a record in the database and update the
 	// movie struct with the system generated information.
-	err = app.models.Movies.Insert(movie)
+	err = app.movies(r).Insert(movie)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrNoOrganization):
+			app.noOrganizationResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
+	app.audit(r, app.contextGetUser(r), "movie.create", "movie", movie.ID, nil, movie)
+
 	// When sending a HTTP response, we want to include a Location header to let the
 	// client know which URL they can find the newly created resource. we make an
 	// empty http.Header map and then use the Set() method to add a new Location header,
@@ -87,7 +96,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Call the Get() method to fetch the data for a specific movie
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.movies(r).Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -122,7 +131,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Fetch the existing movie record from the database, send a 404 Not Found
 	// response to the client if we couldn't  find a matching record.
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.movies(r).Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -133,6 +142,22 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Users who only have the movies:write:own permission may only change the movies
+	// that they created.
+	editor, err := app.movieEditor(r, "movies:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !editor.CanEdit(movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	// Keep a copy of the movie as it was before the changes, for the audit log.
+	before := *movie
+
 	// Declare an input struct to hold the expected data from the client.
 	var input struct {
 		Title   *string       `json:"title"`
@@ -183,7 +208,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Intercept any ErrEditConflict error and call the new editConflictResponse()
 	// helper
-	err = app.models.Movies.Update(movie)
+	err = app.movies(r).Update(movie, editor)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -194,6 +219,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, app.contextGetUser(r), "movie.update", "movie", movie.ID, &before, movie)
+
 	// Write the updated movie record in a JSON response.
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
@@ -211,9 +238,34 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Fetch the movie first, so that we can record what was deleted in the audit log,
+	// and so that we can check who created it: users who only have the
+	// movies:delete:own permission may only delete the movies that they created.
+	movie, err := app.movies(r).Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	editor, err := app.movieEditor(r, "movies:delete")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !editor.CanEdit(movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	// Delete the movie from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	err = app.movies(r).Delete(id, editor)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -224,6 +276,13 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, app.contextGetUser(r), "movie.delete", "movie", movie.ID, movie, nil)
+
+	// Delete the movie's poster images too, now that nothing refers to them.
+	if movie.Poster != nil {
+		app.deletePosterBlobs(r, movie.Poster)
+	}
+
 	// Return a 200 OK status code along with a success message
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
@@ -238,8 +297,10 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// To keep things consistent with other handlers, we'll define an input struct
 	// to hold the expected values from the request query string.
 	var input struct {
-		Title  string
-		Genres []string
+		Title        string
+		Genres       []string
+		PersonID     int64
+		CollectionID int64
 		data.Filters
 	}
 
@@ -255,6 +316,14 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// The person filter lets clients list every movie by a given director, or with a
+	// given actor, using the ID of the person.
+	input.PersonID = int64(app.readInt(qs, "person", 0, v))
+	v.Check(input.PersonID >= 0, "person", "must not be negative")
+
+	input.CollectionID = int64(app.readInt(qs, "collection_id", 0, v))
+	v.Check(input.CollectionID >= 0, "collection_id", "must not be negative")
+
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here.
@@ -269,7 +338,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Read the sort query string value into the embedded struct
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	// Add the supported sort values for this endpoint to the sort safelist
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "average_rating", "ratings_count", "-id", "-title", "-year", "-runtime", "-average_rating", "-ratings_count"}
 
 	// Execute the validation checks on the Filters struct and send a response
 	// containing the errors if necessary.
@@ -282,7 +351,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// params
 
 	// Accept the metadata struct as a return value
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.movies(r).GetAll(input.Title, input.Genres, input.PersonID, input.CollectionID, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -296,3 +365,25 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The movieEditor() helper describes the current user as a movie editor. If they don't
+// have the given permission (which lets them change any movie), then they're only
+// allowed to change the movies that they created; the routes make sure that they have
+// the corresponding ":own" permission.
+func (app *application) movieEditor(r *http.Request, code string) (data.MovieEditor, error) {
+
+	user := app.contextGetUser(r)
+
+	permissions, err := app.userPermissions(r, user)
+	if err != nil {
+		return data.MovieEditor{}, err
+	}
+
+	return data.MovieEditor{UserID: user.ID, OwnOnly: !permissions.Include(code)}, nil
+}
+
+// The movies() helper returns a MovieModel for the organization that the user is
+// working in. Handlers must always use this rather than app.models.Movies directly.
+func (app *application) movies(r *http.Request) data.MovieModel {
+	return app.models.Movies.ForOrganization(app.contextGetOrganization(r))
+}
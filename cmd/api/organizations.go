@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// List the organizations that the current user is a member of, along with the ID of the
+// one they are currently working in.
+func (app *application) listCurrentUserOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	organizations, err := app.models.Organizations.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organizations": organizations, "active_organization_id": app.contextGetOrganization(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Switch the current session to a different organization. The user must be a member of
+// the organization. This changes the organization of the session's refresh token too,
+// so the switch lasts until the user logs out.
+//
+// Signed authentication tokens can't be changed, so if they are enabled we send back a
+// new authentication token for the new organization, which the client must use from now
+// on. The old token stays valid until it expires, but because the user is a member of
+// both organizations that doesn't let them see anything they couldn't already see.
+func (app *application) switchOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		OrganizationID int64 `json:"organization_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	v.Check(input.OrganizationID > 0, "organization_id", "must be provided")
+
+	if v.Valid() {
+		member, err := app.models.Organizations.IsMember(input.OrganizationID, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		v.Check(member, "organization_id", "you are not a member of this organization")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	organization, err := app.models.Organizations.Get(input.OrganizationID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	tokenHash := app.contextGetTokenHash(r)
+
+	// In signed mode, the old token keeps its organization claim until it expires, so
+	// we ask for a replacement token and the old one is revoked.
+	signed := app.config.auth.tokenMode == "signed"
+
+	token, err := app.models.Tokens.SetOrganization(tokenHash, organization.ID, signed)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"organization": organization}
+
+	if signed {
+		err = app.signAuthenticationToken(token, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env["authentication_token"] = token
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// List all organizations.
+func (app *application) listOrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "slug", "created_at", "-id", "-name", "-slug", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	organizations, metadata, err := app.models.Organizations.GetAll(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organizations": organizations, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Create a new organization. Nobody is a member of the new organization until they are
+// added with updateOrganizationMembersHandler.
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	organization := &data.Organization{
+		Name: input.Name,
+		Slug: input.Slug,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, organization); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.Insert(organization)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "an organization with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, app.contextGetUser(r), "organization.create", "organization", organization.ID, nil, organization)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": organization}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Add a user to an organization (POST) or remove them from it (DELETE). Removing a user
+// also revokes their tokens for the organization.
+func (app *application) updateOrganizationMembersHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	organization, err := app.models.Organizations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.UserID > 0, "user_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "no matching user found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		err = app.models.Organizations.AddMember(organization.ID, user.ID)
+	case http.MethodDelete:
+		err = app.models.Organizations.RemoveMember(organization.ID, user.ID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "the user is not a member of this organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	action := "organization.member.add"
+	if r.Method == http.MethodDelete {
+		action = "organization.member.remove"
+	}
+
+	app.audit(r, app.contextGetUser(r), action, "organization", organization.ID, nil, map[string]any{"user_id": user.ID})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"organization": organization, "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
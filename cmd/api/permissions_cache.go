@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The listenForPermissionChanges() method listens for notifications on the
+// permissions_changed channel, which are sent by triggers in the database whenever
+// anybody's permissions change, and clears the affected entries from our permissions
+// cache. This keeps the cache correct when the change was made by another instance of
+// the application (or directly in the database).
+func (app *application) listenForPermissionChanges() error {
+
+	cache := app.models.Permissions.Cache
+
+	// Log any problems with the connection. While the listener is reconnecting we
+	// might miss notifications, so we clear the whole cache once it has reconnected.
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.Error("permissions listener: " + err.Error())
+		}
+
+		if event == pq.ListenerEventReconnected {
+			cache.InvalidateAll()
+		}
+	})
+
+	err := listener.Listen("permissions_changed")
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+
+		for {
+
+			select {
+			case n := <-listener.Notify:
+				// A nil notification means that the connection was re-established.
+				if n == nil || n.Extra == "*" {
+					cache.InvalidateAll()
+					continue
+				}
+
+				userID, err := strconv.ParseInt(n.Extra, 10, 64)
+				if err != nil {
+					app.logger.Error("permissions listener: invalid payload", "payload", n.Extra)
+					cache.InvalidateAll()
+					continue
+				}
+
+				cache.Invalidate(userID)
+
+			case <-time.After(90 * time.Second):
+				// Check that the connection is still alive if we haven't heard anything
+				// for a while.
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/storage"
+	"github.com/high-la/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Define an error for uploads which don't include the expected file.
+var errMissingUpload = errors.New("missing upload")
+
+// Upload a poster for a movie. The request must be multipart/form-data, with the image
+// in a part called "poster". Posters can be much bigger than the 1MB that readJSON()
+// allows, so we stream the upload to a temporary file rather than reading it into
+// memory, with its own size limit. Then we check what the image really is, store it
+// along with its thumbnails, and point the movie at them.
+func (app *application) updateMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+
+	movie, ok := app.readMovieParam(w, r)
+	if !ok {
+		return
+	}
+
+	editor, err := app.movieEditor(r, "movies:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !editor.CanEdit(movie) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	limit := app.config.uploads.posterMaxBytes
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	file, err := app.readUpload(r, "poster")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.requestTooLargeResponse(w, r, limit)
+		case errors.Is(err, errMissingUpload):
+			v.AddError("poster", "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, http.ErrNotMultipart), errors.Is(err, http.ErrMissingBoundary):
+			app.badRequestResponse(w, r, errors.New("body must be multipart/form-data"))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// Work out what the file really is from its first 512 bytes, ignoring whatever
+	// content type or file name the client sent, and then read the image dimensions
+	// from its header. We check the dimensions before decoding the whole image, so
+	// that a small file which claims to be a huge image can't use up all our memory.
+	header := make([]byte, 512)
+
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	contentType := http.DetectContentType(header[:n])
+
+	var config image.Config
+
+	if validator.PermittedValue(contentType, "image/jpeg", "image/png") {
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		config, _, err = image.DecodeConfig(file)
+		if err != nil {
+			v.AddError("poster", "must be a valid JPEG or PNG image")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	if data.ValidatePosterImage(v, contentType, config.Width, config.Height); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		v.AddError("poster", "must be a valid JPEG or PNG image")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	poster, err := app.storePoster(r, movie.ID, file, contentType, img)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	before := *movie
+
+	err = app.movies(r).UpdatePoster(movie, poster, editor)
+	if err != nil {
+		app.deletePosterBlobs(r, &poster)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The old poster isn't needed any more.
+	if before.Poster != nil {
+		app.deletePosterBlobs(r, before.Poster)
+	}
+
+	app.audit(r, app.contextGetUser(r), "movie.poster.update", "movie", movie.ID, &before, movie)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Serve a poster image or thumbnail from the blob store. Keys include a random part
+// which changes with every upload, so clients can cache the images forever.
+func (app *application) showPosterHandler(w http.ResponseWriter, r *http.Request) {
+
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("key"), "/")
+
+	if !strings.HasPrefix(key, "posters/") {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	blob, err := app.blobs.Open(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrInvalidKey):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(key)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	_, err = io.Copy(w, blob)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The readUpload() helper streams the part of a multipart/form-data request body with
+// the given name into a temporary file, and returns the file. Other parts are skipped.
+// The caller must close and remove the file.
+func (app *application) readUpload(r *http.Request, name string) (*os.File, error) {
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errMissingUpload
+			}
+			return nil, err
+		}
+
+		if part.FormName() != name {
+			part.Close()
+			continue
+		}
+
+		return copyToTempFile(part)
+	}
+}
+
+func copyToTempFile(part *multipart.Part) (*os.File, error) {
+
+	defer part.Close()
+
+	file, err := os.CreateTemp("", "greenlight-upload-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(file, part)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// The storePoster() helper saves the original image and its thumbnails in the blob
+// store, under a new random key for the movie, like
+// "posters/42/3f9a1c0e7b2d4a61/original.png". If anything fails, the blobs which were
+// already stored are deleted again.
+func (app *application) storePoster(r *http.Request, movieID int64, file *os.File, contentType string, img image.Image) (data.Poster, error) {
+
+	randomBytes := make([]byte, 8)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return data.Poster{}, err
+	}
+
+	extension := ".jpg"
+	if contentType == "image/png" {
+		extension = ".png"
+	}
+
+	bounds := img.Bounds()
+
+	poster := data.Poster{
+		Key:    fmt.Sprintf("posters/%d/%s/original%s", movieID, hex.EncodeToString(randomBytes), extension),
+		Width:  int32(bounds.Dx()),
+		Height: int32(bounds.Dy()),
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return data.Poster{}, err
+	}
+
+	err = app.blobs.Put(r.Context(), poster.Key, file)
+	if err != nil {
+		app.deletePosterBlobs(r, &poster)
+		return data.Poster{}, err
+	}
+
+	// Thumbnails are JPEGs, so any transparent parts of a PNG are drawn on white.
+	flattened := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flattened, flattened.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flattened, flattened.Bounds(), img, bounds.Min, draw.Over)
+
+	for _, thumbnail := range data.PosterThumbnails {
+
+		var buf bytes.Buffer
+
+		err = jpeg.Encode(&buf, resizeImage(flattened, thumbnail.Width), &jpeg.Options{Quality: 85})
+		if err != nil {
+			app.deletePosterBlobs(r, &poster)
+			return data.Poster{}, err
+		}
+
+		err = app.blobs.Put(r.Context(), data.PosterThumbnailKey(poster.Key, thumbnail.Name), &buf)
+		if err != nil {
+			app.deletePosterBlobs(r, &poster)
+			return data.Poster{}, err
+		}
+	}
+
+	return poster, nil
+}
+
+// The deletePosterBlobs() helper deletes the original image and thumbnails of a poster.
+// Errors are logged rather than returned, since there's nothing that the client can do
+// about them and the worst case is some unused files.
+func (app *application) deletePosterBlobs(r *http.Request, poster *data.Poster) {
+
+	for _, key := range poster.Keys() {
+		err := app.blobs.Delete(r.Context(), key)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+// The resizeImage() function scales an image down to the given width, keeping its
+// aspect ratio, by averaging the source pixels which fall into each destination pixel.
+// Images which are already narrower than the width are copied at their original size.
+func resizeImage(src *image.RGBA, width int) *image.RGBA {
+
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	if width > srcWidth {
+		width = srcWidth
+	}
+
+	height := max(1, (srcHeight*width+srcWidth/2)/srcWidth)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+
+		for x := range width {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+
+			var sum [4]int
+			count := 0
+
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(src.Bounds().Min.X+x0, src.Bounds().Min.Y+sy)
+
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += int(src.Pix[i])
+					sum[1] += int(src.Pix[i+1])
+					sum[2] += int(src.Pix[i+2])
+					sum[3] += int(src.Pix[i+3])
+					i += 4
+					count++
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			for c := range 4 {
+				dst.Pix[j+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+
+	return dst
+}
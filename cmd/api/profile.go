@@ -0,0 +1,597 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// Show the details of the current user.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Fetch the user's full details from the database, because the user in the request
+	// context might only include their ID and activation status.
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Update the details of the current user. At the moment the only field which can be
+// changed this way is the user's name; changing the email address or password go
+// through their own endpoints because they need extra checks.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// As with movies, we use a pointer so that we can tell whether a value was provided
+	// in the JSON request body or not.
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Save the updated user record, checking for any edit conflicts as normal.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Change the current user's password. Unlike resetting a forgotten password, the user
+// must provide their current password, so that someone who has stolen an authentication
+// token can't lock the user out of their account.
+func (app *application) changeCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, "current_password", input.CurrentPassword) {
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Any outstanding password reset tokens are no longer needed.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Log the user out of all of their other sessions, but keep the current one so that
+	// they don't have to log in again straight away.
+	err = app.models.Tokens.DeleteOtherSessions(user.ID, app.contextGetTokenHash(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "your password was successfully changed"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Start changing the current user's email address. We don't change the address
+// straight away; instead we send a confirmation token to the new address, and only
+// swap it in once the user has proven that they own it.
+func (app *application) createEmailChangeTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Require the user's password, for the same reason as when changing it.
+	if !app.checkCurrentPassword(w, r, user, "password", input.Password) {
+		return
+	}
+
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different from your current email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that the new address isn't already in use, so that we can tell the user
+	// now rather than after they've confirmed it.
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.emailLimiter.allow(input.Email) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// Delete any email change tokens that were issued previously, so that only the
+	// most recent request can be confirmed.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.NewEmailChange(user.ID, 24*time.Hour, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Email the confirmation token to the new address.
+	app.background(func() {
+		data := map[string]any{
+			"emailChangeToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(token.Email, "token_email_change.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to your new email address containing confirmation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Verify an email change token and swap in the user's new email address. Like the
+// activation and password reset endpoints, this doesn't require authentication because
+// the token itself proves who the user is.
+func (app *application) updateUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.GetEmailChange(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The user might have been deleted since the token was issued, in which case we
+	// treat the token as invalid.
+	user, err := app.models.Users.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = token.Email
+
+	// Save the updated user record. Someone else may have claimed the address since
+	// the token was issued, in which case the Update() method returns
+	// ErrDuplicateEmail.
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Delete any outstanding email change, password reset and magic link login tokens,
+	// since the password reset and login tokens were sent to the old address, which
+	// might be in the hands of somebody else.
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopePasswordReset, data.ScopeLogin} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Let the user know at their old address, so that they notice if someone else has
+	// taken over their account.
+	app.background(func() {
+		data := map[string]any{
+			"newEmail": user.Email,
+		}
+
+		err := app.mailer.Send(oldEmail, "user_email_changed.tmpl.html", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Delete the current user's account. The user must confirm their password. The account
+// is soft deleted straight away, which logs the user out everywhere and frees up their
+// email address for a new account, and it's purged for good by a background job once
+// the grace period has passed.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, "password", input.Password) {
+		return
+	}
+
+	err = app.models.Users.SoftDelete(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	purgeAt := time.Now().Add(app.config.accounts.deletionGracePeriod)
+
+	env := envelope{
+		"message":  "your account has been deleted",
+		"purge_at": purgeAt.UTC().Truncate(time.Second),
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Export all of the personal data that we hold about the current user as a JSON
+// archive.
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	accessTokens, err := app.models.Tokens.GetAllForUser(data.ScopePersonalAccess, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	t, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Include the movies that the user created, their reviews and their lists in every
+	// organization that they belong to, not just the one that they are currently
+	// working in.
+	organizations, err := app.models.Organizations.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	movies := []*data.Movie{}
+	reviews := []*data.Review{}
+	lists := []*data.List{}
+
+	for _, organization := range organizations {
+		created, err := app.models.Movies.ForOrganization(organization.ID).GetAllCreatedBy(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		movies = append(movies, created...)
+
+		written, err := app.models.Reviews.ForOrganization(organization.ID).GetAllWrittenBy(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		reviews = append(reviews, written...)
+
+		owned, err := app.models.Lists.ForOrganization(organization.ID).GetAllOwnedBy(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		lists = append(lists, owned...)
+	}
+
+	// Include the movies in each list too, so that the export is complete.
+	for _, list := range lists {
+		list.Movies, err = app.models.Lists.GetMovies(list.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Make sure that we send an empty array rather than null if the user has no
+	// permissions.
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	export := envelope{
+		"exported_at":        time.Now().UTC().Truncate(time.Second),
+		"user":               user,
+		"roles":              roles,
+		"permissions":        permissions,
+		"organizations":      organizations,
+		"sessions":           sessions,
+		"access_tokens":      accessTokens,
+		"two_factor_enabled": t != nil && t.Confirmed,
+		"movies":             movies,
+		"reviews":            reviews,
+		"lists":              lists,
+	}
+
+	// Ask browsers to download the archive as a file, rather than displaying it.
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", `attachment; filename="greenlight-export.json"`)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The checkCurrentPassword() helper checks the password that a logged in user has
+// provided to confirm a sensitive change to their account. Wrong passwords count as
+// failed logins, and are throttled and locked out in the same way, so that someone who
+// has stolen an authentication token can't use it to guess the password. It returns
+// false if the password is wrong or the client has to wait, in which case a response
+// has already been sent. The field is the name of the input field for error messages.
+func (app *application) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *data.User, field, plaintext string) bool {
+
+	lockout, ok := app.checkLoginWait(w, r, user.Email)
+	if !ok {
+		return false
+	}
+
+	match, err := user.Password.Matches(plaintext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !match {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+
+		v := validator.New()
+		v.AddError(field, "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	if lockout != nil {
+		err = app.models.Lockouts.DeleteForEmail(user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+
+	return true
+}
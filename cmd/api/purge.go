@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+)
+
+// The startAccountPurge() method launches a background goroutine which periodically
+// deletes the accounts of users who deleted them more than the grace period ago.
+func (app *application) startAccountPurge() {
+
+	go func() {
+
+		for {
+
+			time.Sleep(app.config.accounts.purgeInterval)
+
+			count, err := app.models.Users.PurgeDeleted(time.Now().Add(-app.config.accounts.deletionGracePeriod))
+			if err != nil {
+				app.logger.Error(err.Error())
+				continue
+			}
+
+			if count > 0 {
+				app.logger.Info("purged deleted accounts", "count", count)
+			}
+		}
+	}()
+}
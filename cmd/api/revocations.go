@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// The revocationList type holds an in-memory copy of the token_revocations table, so
+// that the authenticate() middleware can check whether a signed authentication token
+// has been revoked without making a database query.
+type revocationList struct {
+	mu     sync.RWMutex
+	hashes map[[sha256.Size]byte]time.Time
+}
+
+// The newRevocationList() function returns a new, empty, revocationList instance.
+func newRevocationList() *revocationList {
+
+	return &revocationList{
+		hashes: make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// The contains() method reports whether the token with the given SHA-256 hash has been
+// revoked.
+func (rl *revocationList) contains(tokenHash []byte) bool {
+
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	_, found := rl.hashes[[sha256.Size]byte(tokenHash)]
+	return found
+}
+
+// The syncRevocations() method reloads the unexpired revocations from the database and
+// replaces the in-memory revocation list with them. Entries for tokens which have
+// expired drop out of the list, because they would be rejected anyway.
+func (app *application) syncRevocations() error {
+
+	revocations, err := app.models.Revocations.GetAllUnexpired()
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[[sha256.Size]byte]time.Time, len(revocations))
+
+	for _, revocation := range revocations {
+		hashes[[sha256.Size]byte(revocation.Hash)] = revocation.Expiry
+	}
+
+	app.revocations.mu.Lock()
+	app.revocations.hashes = hashes
+	app.revocations.mu.Unlock()
+
+	return nil
+}
+
+// The startRevocationSync() method launches a background goroutine which keeps the
+// in-memory revocation list up to date, and periodically deletes expired revocations
+// from the database.
+func (app *application) startRevocationSync() {
+
+	go func() {
+
+		lastCleanup := time.Now()
+
+		for {
+
+			time.Sleep(app.config.auth.revocationSyncInterval)
+
+			err := app.syncRevocations()
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+
+			if time.Since(lastCleanup) > time.Hour {
+				err = app.models.Revocations.DeleteExpired()
+				if err != nil {
+					app.logger.Error(err.Error())
+				}
+
+				lastCleanup = time.Now()
+			}
+		}
+	}()
+}
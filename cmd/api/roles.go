@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// List all of the roles, along with their permissions and the roles they inherit from.
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Change a user's roles. This works in the same way as updateUserPermissionsHandler:
+// PUT replaces all of the user's roles, POST adds roles, and DELETE removes them.
+func (app *application) updateUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := app.readUserParam(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Roles != nil, "roles", "must be provided")
+	v.Check(validator.Unique(input.Roles), "roles", "must not contain duplicate values")
+
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, name := range input.Roles {
+		known := slices.ContainsFunc(roles, func(role *data.Role) bool { return role.Name == name })
+		v.Check(known, "roles", fmt.Sprintf("contains unknown role %q", name))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Fetch the user's roles before the change, for the audit log.
+	before, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		err = app.models.Roles.SetForUser(user.ID, input.Roles...)
+	case http.MethodPost:
+		err = app.models.Roles.AddForUser(user.ID, input.Roles...)
+	case http.MethodDelete:
+		err = app.models.Roles.RemoveForUser(user.ID, input.Roles...)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	userRoles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, app.contextGetUser(r), "roles.update", "user", user.ID, map[string]any{"roles": before}, map[string]any{"roles": userRoles})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": userRoles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -26,19 +26,135 @@ func (app *application) routes() http.Handler {
 	// Use the requirePermission() middleware on each of the /v1/movies** endpoints,
 	// passing in the required permission code as the first parameter.
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
+	//
+	// Creating, changing and deleting movies only needs the ":own" permissions, which
+	// are implied by the global ones. The handlers then check whether the user may
+	// change any movie or only the movies that they created.
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write:own", app.createMovieHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write:own", app.updateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:delete:own", app.deleteMovieHandler))
+
+	// Uploading a poster is a change to the movie, so it needs the same permissions as
+	// updating it. The images themselves are served to anybody, so that they can be
+	// used directly in <img> tags.
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write:own", app.updateMoviePosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/posters/*key", app.showPosterHandler)
+
+	// Anybody who can read the movies can see the reviews, but writing them needs the
+	// reviews:write permission. Users can only change their own reviews, which the
+	// handlers check.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("reviews:write", app.createReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission("reviews:write", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission("reviews:write", app.deleteReviewHandler))
+
+	// The credits of a movie are changed with the same permissions as the movie itself.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write:own", app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write:own", app.deleteMovieCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:delete", app.deletePersonHandler))
+
+	// Anybody who can read the movies can see the collections, but changing them needs
+	// the global movies:write permission, since collections can include movies created
+	// by anybody.
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.requirePermission("movies:read", app.listCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermission("movies:write", app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.requirePermission("movies:read", app.showCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requirePermission("movies:write", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requirePermission("movies:write", app.deleteCollectionHandler))
 
 	// .
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activations", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.updateUserEmailHandler)
+
+	// Endpoints which manage the user's credentials and account are wrapped with the
+	// denyImpersonation() middleware, so that support staff impersonating the user
+	// can't use them.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireActivatedUser(app.denyImpersonation(app.updateCurrentUserHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenticatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.deleteCurrentUserHandler))))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireAuthenticatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.exportCurrentUserHandler))))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireActivatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.changeCurrentUserPasswordHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requireActivatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.createEmailChangeTokenHandler))))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireAuthenticatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.deleteSessionHandler))))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/access-tokens", app.requireActivatedUser(app.listAccessTokensHandler))
+	// Don't allow personal access tokens to be used to create, revoke or log out other
+	// tokens; otherwise a leaked token could be used to mint new ones which outlive it,
+	// or to lock the owner out of their own account.
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/access-tokens", app.requireActivatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.createAccessTokenHandler))))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/access-tokens/:id", app.requireActivatedUser(app.denyPersonalAccessTokens(app.deleteAccessTokenHandler)))
+
+	// Users who belong to several organizations can switch the organization that
+	// their session is working in.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/organizations", app.requireAuthenticatedUser(app.listCurrentUserOrganizationsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/organization", app.requireAuthenticatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.switchOrganizationHandler))))
+
+	// Users can only see and change their own lists; the handlers check ownership.
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/lists", app.requireActivatedUser(app.listCurrentUserListsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/lists", app.requireActivatedUser(app.createListHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/lists/:id", app.requireActivatedUser(app.showCurrentUserListHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/lists/:id", app.requireActivatedUser(app.updateListHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/lists/:id", app.requireActivatedUser(app.deleteListHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/lists/:id/items", app.requireActivatedUser(app.addListItemHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/lists/:id/items", app.requireActivatedUser(app.reorderListItemsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/lists/:id/items/:movie_id", app.requireActivatedUser(app.removeListItemHandler))
+
+	// Public lists can be shared with the other members of the list's organization, so
+	// this endpoint only needs the user to be logged in.
+	router.HandlerFunc(http.MethodGet, "/v1/lists/:slug", app.requireActivatedUser(app.showPublicListHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireActivatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.createTOTPHandler))))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/totp", app.requireActivatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.confirmTOTPHandler))))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireActivatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.deleteTOTPHandler))))
+
+	// The admin endpoints for managing users all require the users:admin permission.
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/admin/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requirePermission("users:admin", app.deleteUserTokensHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.updateUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.updateUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.updateUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.updateUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.updateUserRolesHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles", app.requirePermission("users:admin", app.updateUserRolesHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:admin", app.listRolesHandler))
+
+	// Support staff with the users:impersonate permission can act as another user.
+	router.HandlerFunc(http.MethodPost, "/v1/admin/impersonate/:user_id", app.requirePermission("users:impersonate", app.denyImpersonation(app.impersonateUserHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/organizations", app.requirePermission("users:admin", app.listOrganizationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/organizations", app.requirePermission("users:admin", app.createOrganizationHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/organizations/:id/members", app.requirePermission("users:admin", app.updateOrganizationMembersHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/organizations/:id/members", app.requirePermission("users:admin", app.updateOrganizationMembersHandler))
+
+	// The audit log can only be read by users with the audit:read permission.
+	router.HandlerFunc(http.MethodGet, "/v1/audit-events", app.requirePermission("audit:read", app.listAuditEventsHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.denyPersonalAccessTokens(app.deleteAuthenticationTokenHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.denyPersonalAccessTokens(app.denyImpersonation(app.deleteAllAuthenticationTokensHandler))))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
 
 	// Register a new GET /debug/vars endpoint pointing to the expvar handler.
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
 	// Return the httprouter instance.
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.requestID(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))))
 }
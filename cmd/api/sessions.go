@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/high-la/greenlight/internal/data"
+)
+
+// The sessionTouchInterval constant is the minimum amount of time between two updates
+// of the last_used_at column for the same authentication token.
+const sessionTouchInterval = time.Minute
+
+// The sessionToucher type coalesces writes to the tokens table. Without it, every
+// authenticated request would cause an UPDATE query; with it, we record the last use of
+// each token at most once every sessionTouchInterval.
+type sessionToucher struct {
+	mu      sync.Mutex
+	touched map[[sha256.Size]byte]time.Time
+}
+
+// The newSessionToucher() function returns a new sessionToucher and launches a
+// background goroutine which removes old entries from the touched map once every
+// minute.
+func newSessionToucher() *sessionToucher {
+
+	st := &sessionToucher{
+		touched: make(map[[sha256.Size]byte]time.Time),
+	}
+
+	go func() {
+
+		for {
+
+			time.Sleep(time.Minute)
+
+			st.mu.Lock()
+
+			for hash, touchedAt := range st.touched {
+				if time.Since(touchedAt) > sessionTouchInterval {
+					delete(st.touched, hash)
+				}
+			}
+
+			st.mu.Unlock()
+		}
+	}()
+
+	return st
+}
+
+// The shouldTouch() method reports whether the last use of the token with the given
+// hash needs to be written to the database. Note that we key the map on the token hash,
+// so that we don't keep plaintext tokens in memory for longer than necessary.
+func (st *sessionToucher) shouldTouch(tokenHash []byte) bool {
+
+	hash := [sha256.Size]byte(tokenHash)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if touchedAt, found := st.touched[hash]; found && time.Since(touchedAt) < sessionTouchInterval {
+		return false
+	}
+
+	st.touched[hash] = time.Now()
+
+	return true
+}
+
+// The revokeAllSessions() helper logs a user out everywhere, by deleting all of their
+// authentication and refresh tokens.
+func (app *application) revokeAllSessions(userID int64) error {
+
+	for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(scope, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List the current user's active sessions (i.e. their unexpired authentication tokens).
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	tokens, err := app.models.Tokens.GetAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Wrap each token in a session struct which also indicates whether it's the token
+	// that was used to make this request.
+	type session struct {
+		*data.Token
+		Current bool `json:"current"`
+	}
+
+	currentHash := app.contextGetTokenHash(r)
+
+	sessions := make([]session, len(tokens))
+	for i, token := range tokens {
+		sessions[i] = session{
+			Token:   token,
+			Current: bytes.Equal(token.Hash, currentHash),
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke one of the current user's sessions. Because the DeleteForUser() method also
+// deletes the other tokens in the same token family, this revokes the session's refresh
+// token too.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// Importantly, we pass in the user ID as well as the session ID here, so that users
+	// can't revoke sessions which belong to someone else.
+	err = app.models.Tokens.DeleteForUser(data.ScopeAuthentication, id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, user, "token.revoke", "token", id, map[string]any{"scope": data.ScopeAuthentication}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The emailLimiter type limits how many emails we will send to a single email address
+// within an hour. It works in the same way as the rateLimit() middleware, except that
+// the clients are keyed by email address rather than by IP address, so it protects
+// people from being spammed by endpoints which send emails on request.
+type emailLimiter struct {
+	mu      sync.Mutex
+	perHour int
+	enabled bool
+	clients map[string]*emailLimiterClient
+}
+
+// Define an emailLimiterClient struct to hold the rate limiter and last seen time for
+// each email address.
+type emailLimiterClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// The newEmailLimiter() function returns a new emailLimiter instance and launches a
+// background goroutine which removes old entries from the clients map once every
+// minute.
+func newEmailLimiter(perHour int, enabled bool) *emailLimiter {
+
+	// A limit of less than one email per hour doesn't make sense (and would cause a
+	// division by zero below), so we treat it as one.
+	l := &emailLimiter{
+		perHour: max(perHour, 1),
+		enabled: enabled,
+		clients: make(map[string]*emailLimiterClient),
+	}
+
+	go func() {
+
+		for {
+
+			time.Sleep(time.Minute)
+
+			l.mu.Lock()
+
+			// Once an address hasn't been seen for an hour its limiter is full again,
+			// so it's safe to forget about it.
+			for email, client := range l.clients {
+				if time.Since(client.lastSeen) > time.Hour {
+					delete(l.clients, email)
+				}
+			}
+
+			l.mu.Unlock()
+		}
+	}()
+
+	return l
+}
+
+// The allow() method reports whether another email may be sent to the given address
+// right now. Email addresses are compared case-insensitively, in the same way as the
+// citext email column in our users table.
+func (l *emailLimiter) allow(email string) bool {
+
+	if !l.enabled {
+		return true
+	}
+
+	email = strings.ToLower(email)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, found := l.clients[email]; !found {
+		// Allow a burst of perHour emails, with the allowance refilling evenly over the
+		// course of an hour.
+		l.clients[email] = &emailLimiterClient{
+			limiter: rate.NewLimiter(rate.Every(time.Hour/time.Duration(l.perHour)), l.perHour),
+		}
+	}
+
+	l.clients[email].lastSeen = time.Now()
+
+	return l.clients[email].limiter.Allow()
+}
+
+// The backoff() function returns how long a client has to wait after a failed login
+// attempt before they may try again. The delay doubles with each consecutive failure,
+// starting at base and never exceeding limit.
+func backoff(failures int, base, limit time.Duration) time.Duration {
+
+	if failures <= 0 || base <= 0 {
+		return 0
+	}
+
+	delay := base
+
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= limit {
+			return limit
+		}
+	}
+
+	return min(delay, limit)
+}
+
+// The loginThrottle type tracks failed login attempts by IP address. Each failure
+// increases the delay before the IP address may try again, and once an IP address
+// reaches maxAttempts failures it's blocked until the window has passed since its last
+// failure. This complements the per-account lockouts, which catch attackers who spread
+// their attempts across many IP addresses.
+type loginThrottle struct {
+	mu          sync.Mutex
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	window      time.Duration
+	enabled     bool
+	clients     map[string]*loginThrottleClient
+}
+
+// Define a loginThrottleClient struct to hold the number of failures and the time of
+// the last failure for each IP address.
+type loginThrottleClient struct {
+	failures     int
+	lastFailedAt time.Time
+}
+
+// The newLoginThrottle() function returns a new loginThrottle instance and launches a
+// background goroutine which forgets about IP addresses once their failures are older
+// than the window.
+func newLoginThrottle(maxAttempts int, baseDelay, maxDelay, window time.Duration, enabled bool) *loginThrottle {
+
+	t := &loginThrottle{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		window:      window,
+		enabled:     enabled,
+		clients:     make(map[string]*loginThrottleClient),
+	}
+
+	go func() {
+
+		for {
+
+			time.Sleep(time.Minute)
+
+			t.mu.Lock()
+
+			for ip, client := range t.clients {
+				if time.Since(client.lastFailedAt) > t.window {
+					delete(t.clients, ip)
+				}
+			}
+
+			t.mu.Unlock()
+		}
+	}()
+
+	return t
+}
+
+// The wait() method returns how long the given IP address has to wait before it may
+// make another login attempt, or zero if it may try now.
+func (t *loginThrottle) wait(ip string) time.Duration {
+
+	if !t.enabled {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, found := t.clients[ip]
+	if !found {
+		return 0
+	}
+
+	until := client.lastFailedAt.Add(backoff(client.failures, t.baseDelay, t.maxDelay))
+
+	if client.failures >= t.maxAttempts {
+		until = client.lastFailedAt.Add(t.window)
+	}
+
+	return max(time.Until(until), 0)
+}
+
+// The fail() method records a failed login attempt from the given IP address.
+func (t *loginThrottle) fail(ip string) {
+
+	if !t.enabled {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, found := t.clients[ip]
+	if !found || time.Since(client.lastFailedAt) > t.window {
+		client = &loginThrottleClient{}
+		t.clients[ip] = client
+	}
+
+	client.failures++
+	client.lastFailedAt = time.Now()
+}
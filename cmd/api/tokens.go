@@ -1,20 +1,24 @@
 package main
 
 import (
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"time"
 
 	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/jwt"
 	"github.com/high-la/greenlight/internal/validator"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 
-	// Parse the email and password from the request body
+	// Parse the email and password from the request body, along with the optional
+	// two-factor authentication code (which can also be a recovery code).
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		TOTPCode string `json:"totp_code"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -34,13 +38,47 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Before doing anything else, check that the client's IP address hasn't failed to
+	// log in too many times recently.
+	if wait := app.loginThrottle.wait(app.clientIP(r)); wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
+	// Check whether logins for the email address are locked, or whether the client
+	// needs to wait a bit longer after their last failed attempt. We do this before
+	// looking up the user or checking the password, so that an attacker can't keep
+	// guessing while the account is locked, and so that the response is the same
+	// whether or not the email address belongs to an account.
+	lockout, wait, locked, err := app.accountLoginWait(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if locked {
+		app.accountLockedResponse(w, r, wait)
+		return
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
-	// Unauthorized response to the client
+	// found, then we record the failure against the email address in the same way as
+	// for a wrong password, and call the app.invalidCredentialsResponse() helper to
+	// send a 401 Unauthorized response to the client
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.recordLoginFailure(r, input.Email, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -55,24 +93,423 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return.
+	// If the passwords don't match, then we record the failure and call the
+	// app.invalidCredentialsResponse() helper again and return.
 	if !match {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// If the user has enabled two-factor authentication, then they must also provide
+	// a valid code.
+	if !app.checkSecondFactor(w, r, user, input.TOTPCode) {
+		return
+	}
+
+	// The login was successful, so clear any failed attempts for the account.
+	if lockout != nil {
+		err = app.models.Lockouts.DeleteForEmail(user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Otherwise, if the password is correct, we start a new session for the user.
+	app.startSession(w, r, user)
+}
+
+// The checkSecondFactor() helper checks the two-factor authentication code provided by
+// a user who is logging in, if they have enabled two-factor authentication. If they
+// haven't provided a code at all, we send a distinct response so that the client knows
+// to prompt the user for it. It returns false if the login should not go ahead, in
+// which case a response has already been sent.
+func (app *application) checkSecondFactor(w http.ResponseWriter, r *http.Request, user *data.User, code string) bool {
+
+	t, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return true
+		default:
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+
+	if !t.Confirmed {
+		return true
+	}
+
+	if code == "" {
+		app.twoFactorRequiredResponse(w, r)
+		return false
+	}
+
+	ok, err := app.verifySecondFactor(t, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		app.invalidCredentialsResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// The startSession() helper starts a new session for a user who has just logged in.
+// This generates a short-lived token with the scope 'authentication', and a long-lived
+// token with the scope 'refresh' which the client can use to get a new authentication
+// token without logging in again. We also record the IP address and User-Agent of the
+// client, so that the user can see where they are logged in.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *data.User) {
+
+	// New sessions start in the first organization that the user joined. They can
+	// switch to another one later.
+	organizations, err := app.models.Organizations.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var organizationID int64
+	if len(organizations) > 0 {
+		organizationID = organizations[0].ID
+	}
+
+	token, refreshToken, err := app.models.Tokens.NewSession(user.ID, organizationID, app.config.auth.accessTokenTTL, app.config.auth.refreshTokenTTL, app.clientIP(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// If we're issuing signed tokens, then replace the plaintext authentication token
+	// with a signed one.
+	err = app.signAuthenticationToken(token, user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Encode the token to JSON and send it in the response along with a 201 Created
+	app.audit(r, user, "token.issue", "token", token.ID, nil, auditToken(token))
+
+	// Encode the tokens to JSON and send them in the response along with a 201 Created
 	// status code.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Exchange a refresh token for a new authentication token and refresh token.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Parse and validate the refresh token from the request body.
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Rotate the refresh token. If it has been used before then the Rotate() method
+	// revokes every token in the family and returns ErrTokenReused, and we log a
+	// warning because this probably means that the refresh token has been stolen.
+	token, refreshToken, err := app.models.Tokens.Rotate(input.RefreshToken, app.config.auth.accessTokenTTL, app.config.auth.refreshTokenTTL, app.clientIP(r), r.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.Warn("refresh token reused, token family revoked", "ip", app.clientIP(r))
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, &data.User{ID: token.UserID}, "token.refresh", "token", token.ID, nil, auditToken(token))
+
+	// If we're issuing signed tokens, then fetch the user's current details so that we
+	// can sign a new authentication token for them. This means that changes to the
+	// user's activation status and permissions take effect when they next refresh.
+	if app.config.auth.tokenMode == "signed" {
+		user, err := app.models.Users.Get(token.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidRefreshTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.signAuthenticationToken(token, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The signAuthenticationToken() helper replaces the plaintext of a newly created
+// authentication token with a signed token, if signed tokens are enabled. The signed
+// token carries the user's ID, activation status, permissions and organization, and uses
+// the hex-encoded hash of the database token as its jti claim. We don't use the
+// plaintext, because the claims of a signed token are only encoded, not encrypted, and
+// the plaintext would work as a database token by itself. The database token still
+// acts as the record of the session: it can be listed and revoked in exactly the same way, and
+// deleting it adds the signed token to the revocation list.
+func (app *application) signAuthenticationToken(token *data.Token, user *data.User) error {
+
+	if app.config.auth.tokenMode != "signed" {
+		return nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	token.Plaintext, err = app.signer.Sign(jwt.Claims{
+		ID:             hex.EncodeToString(token.Hash),
+		UserID:         user.ID,
+		IssuedAt:       token.CreatedAt.Unix(),
+		Expiry:         token.Expiry.Unix(),
+		Activated:      user.Activated,
+		Permissions:    permissions,
+		OrganizationID: token.OrganizationID,
+		ImpersonatorID: token.ImpersonatorID,
+	})
+
+	return err
+}
+
+// Generate a password reset token and send it to the user's email address.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Parse and validate the user's email address
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Try to retrieve the corresponding user record for the email address. If it can't
+	// be found we don't tell the client -- we send exactly the same response as when
+	// the email is sent, so that this endpoint can't be used to discover which email
+	// addresses have an account.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Only activated users are allowed to reset their password.
+	if user != nil && user.Activated {
+
+		// Delete any password reset tokens that were issued previously, so that only
+		// the most recent token can ever be used.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Otherwise, create a new password reset token with a 45-minute expiry time.
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Email the user with their password reset token.
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			// Since email addresses MAY be case sensitive, notice that we are sending this
+			// email using the address stored in our database for the user --- not to the
+			// input.Email address provided by the client in this request.
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl.html", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	// Send a 202 Accepted response and confirmation message to the client.
+	env := envelope{"message": "if an activated account exists for this email address, you will receive an email containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Generate a fresh activation token and re-send the welcome email, for users whose
+// original email was lost or whose activation token has expired.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Parse and validate the user's email address.
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the email limiter before doing anything else. Because this is keyed on the
+	// email address provided by the client (whether or not it belongs to a user), a 429
+	// response doesn't reveal anything about which accounts exist.
+	if !app.emailLimiter.allow(input.Email) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// Try to retrieve the corresponding user record for the email address. As with
+	// password resets, we send the same response whether or not it exists.
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// There's nothing to do if the user has already been activated.
+	if user != nil && !user.Activated {
+
+		// Delete any existing activation tokens for the user, so that only the one in
+		// the newest email can be used.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Otherwise, create a new activation token.
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Re-send the welcome email with the new activation token.
+		app.background(func() {
+			data := map[string]any{
+				"activationToken": token.Plaintext,
+				"userID":          user.ID,
+			}
+
+			// Again, send this to the email address stored in our database for the
+			// user rather than the input.Email address.
+			err := app.mailer.Send(user.Email, "user_welcome.tmpl.html", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	// Send a 202 Accepted response and confirmation message to the client.
+	env := envelope{"message": "if an unactivated account exists for this email address, you will receive an email containing activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke the authentication token that was used to make the request, along with the
+// refresh token that was issued with it (i.e. log out the current session). Personal
+// access tokens are rejected by the denyPersonalAccessTokens() middleware, so the token
+// here is always an authentication token; they are revoked with
+// DELETE /v1/users/me/access-tokens/:id instead.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Retrieve the plaintext token that the authenticate() middleware recorded in the
+	// request context, and delete it from the database.
+	err := app.models.Tokens.Delete(data.ScopeAuthentication, app.contextGetTokenHash(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	app.audit(r, user, "token.revoke", "user", user.ID, map[string]any{"sessions": "current"}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revoke all authentication and refresh tokens for the current user (i.e. log out every
+// session).
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+
+	user := app.contextGetUser(r)
+
+	err := app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, user, "token.revoke", "user", user.ID, map[string]any{"sessions": "all"}, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/high-la/greenlight/internal/data"
+	"github.com/high-la/greenlight/internal/totp"
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// Start enrolling the current user in two-factor authentication. This generates a new
+// secret and returns it along with an otpauth:// URI, which the client can show as a QR
+// code for the user to scan with their authenticator app.
+func (app *application) createTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Fetch the user's full details from the database, because the user in the request
+	// context might not include their email address.
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Store the new secret. If the user has already confirmed a secret then the Set()
+	// method returns ErrEditConflict, and they need to turn two-factor authentication
+	// off before they can enroll again.
+	err = app.models.TOTP.Set(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"totp": map[string]string{
+			"secret": secret,
+			"uri":    totp.URI(secret, "Greenlight", user.Email),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Finish enrolling the current user in two-factor authentication. The user must send
+// a valid code from their authenticator app to prove that it's set up correctly, and in
+// return we send them a set of single-use recovery codes.
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Fetch the user's full details, because we need their email address to count
+	// failed attempts against their account.
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	t, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if t.Confirmed {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Failed codes count as failed logins, so that they can't be brute-forced.
+	lockout, ok := app.checkLoginWait(w, r, user.Email)
+	if !ok {
+		return
+	}
+
+	ok, err = app.verifySecondFactor(t, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.AddError("code", "invalid two-factor authentication code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if lockout != nil {
+		err = app.models.Lockouts.DeleteForEmail(user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	// Generate the recovery codes and confirm the secret. Only the hashes of the
+	// recovery codes are stored, so this is the only time that the user can see them.
+	recoveryCodes, err := totp.GenerateRecoveryCodes(10)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Confirm(user.ID, recoveryCodes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Turn off two-factor authentication for the current user. To stop someone who has
+// stolen an authentication token from doing this, the request must include a valid code
+// from the user's authenticator app (or one of their recovery codes).
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Fetch the user's full details, because we need their email address to count
+	// failed attempts against their account.
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	t, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Failed codes count as failed logins, so that they can't be brute-forced.
+	lockout, ok := app.checkLoginWait(w, r, user.Email)
+	if !ok {
+		return
+	}
+
+	ok, err = app.verifySecondFactor(t, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.AddError("code", "invalid two-factor authentication code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if lockout != nil {
+		err = app.models.Lockouts.DeleteForEmail(user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The verifySecondFactor() helper checks a code provided by the user against their
+// TOTP settings. The code can either be a 6-digit code from their authenticator app, or
+// one of their recovery codes. Both kinds of code can only be used once.
+func (app *application) verifySecondFactor(t *data.TOTP, code string) (bool, error) {
+
+	code = strings.TrimSpace(code)
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, code); v.Valid() {
+		step, ok := totp.Validate(code, t.Secret, time.Now())
+		if !ok {
+			return false, nil
+		}
+
+		// Record the time step that the code belongs to. This fails if a code from
+		// the same time step has already been used, which stops an attacker who has
+		// seen a code from replaying it.
+		return app.models.TOTP.UseStep(t.UserID, step)
+	}
+
+	// Recovery codes are only accepted once enrollment has been confirmed.
+	if !t.Confirmed {
+		return false, nil
+	}
+
+	return app.models.TOTP.UseRecoveryCode(t.UserID, code)
+}
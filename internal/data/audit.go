@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The AuditEvent struct records a single security-related or data-changing action. The
+// ActorID is the user who carried out the action (or nil if nobody was logged in), and
+// the target is the thing that the action was carried out on. Changes holds the
+// fields that changed, in the form {"before": {...}, "after": {...}}.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   *int64    `json:"actor_id"`
+	// If the actor was impersonating another user, then ActorID is the user they were
+	// impersonating and ImpersonatorID is the real actor.
+	ImpersonatorID *int64          `json:"impersonator_id,omitempty"`
+	Action         string          `json:"action"`
+	TargetType     string          `json:"target_type"`
+	TargetID       string          `json:"target_id,omitempty"`
+	IP             string          `json:"ip,omitempty"`
+	RequestID      string          `json:"request_id,omitempty"`
+	Changes        json.RawMessage `json:"changes,omitempty"`
+}
+
+// Define the AuditEventModel type.
+type AuditEventModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method adds an event to the audit log.
+func (m AuditEventModel) Insert(event *AuditEvent) error {
+
+	query := `
+		INSERT INTO audit_events 
+			(actor_id, action, target_type, target_id, ip, request_id, changes, impersonator_id)
+		VALUES 
+			($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING 
+			id, created_at`
+
+	// A nil json.RawMessage would be sent as an empty string, which isn't valid JSON,
+	// so we send NULL instead.
+	var changes any
+	if len(event.Changes) > 0 {
+		changes = []byte(event.Changes)
+	}
+
+	args := []any{event.ActorID, event.Action, event.TargetType, event.TargetID, event.IP, event.RequestID, changes, event.ImpersonatorID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// The GetAll() method returns a paginated list of audit events. Each of the filter
+// parameters is ignored if it's empty (or, for actorID, zero). The actorID filter also
+// matches the events where that user was impersonating somebody else.
+func (m AuditEventModel) GetAll(actorID int64, action, targetType, targetID string, filters Filters) ([]*AuditEvent, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT 
+			count(*) OVER(), id, created_at, actor_id, action, target_type, target_id, ip,
+			request_id, changes, impersonator_id
+		FROM audit_events
+		WHERE (actor_id = $1 OR impersonator_id = $1 OR $1 = 0)
+		AND (action = $2 OR $2 = '')
+		AND (target_type = $3 OR $3 = '')
+		AND (target_id = $4 OR $4 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{actorID, action, targetType, targetID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+
+		var event AuditEvent
+		var changes []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IP,
+			&event.RequestID,
+			&changes,
+			&event.ImpersonatorID,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		event.Changes = changes
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
@@ -0,0 +1,442 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrMovieInOtherCollection error, which is returned when a movie is
+// added to a collection while it's still in a different one.
+var ErrMovieInOtherCollection = errors.New("movie in other collection")
+
+// The Collection struct represents a series of related movies, like a trilogy. The
+// Movies field is only filled in when showing a single collection.
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	MoviesCount int32     `json:"movies_count"`
+	Movies      []*Movie  `json:"movies,omitempty"`
+	Version     int32     `json:"version"`
+}
+
+// The CollectionSummary struct is included when showing a movie which is in a
+// collection. Position is the movie's place in the collection, starting at 1.
+type CollectionSummary struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Position    int32  `json:"position"`
+	MoviesCount int32  `json:"movies_count"`
+}
+
+// The ValidateCollection() function checks the name and description of a collection.
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(collection.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+}
+
+// The ValidateCollectionMovies() function checks the IDs of the movies in a collection.
+func ValidateCollectionMovies(v *validator.Validator, movieIDs []int64) {
+
+	v.Check(len(movieIDs) <= 100, "movie_ids", "must not contain more than 100 movies")
+	v.Check(validator.Unique(movieIDs), "movie_ids", "must not contain duplicate values")
+}
+
+// Define the CollectionModel type. Like the MovieModel, a CollectionModel only works
+// with the collections in the organization in its OrganizationID field, so handlers
+// must call ForOrganization() to get one.
+type CollectionModel struct {
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the CollectionModel which works with
+// the collections in the given organization.
+func (m CollectionModel) ForOrganization(organizationID int64) CollectionModel {
+	m.OrganizationID = organizationID
+	return m
+}
+
+// The Insert() method creates a new collection containing the given movies, in order.
+func (m CollectionModel) Insert(collection *Collection, movieIDs []int64) error {
+
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO collections
+			(organization_id, name, description)
+		VALUES
+			($1, $2, $3)
+		RETURNING
+			id, created_at, version`
+
+	args := []any{m.OrganizationID, collection.Name, collection.Description}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+	if err != nil {
+		return err
+	}
+
+	err = m.setMovies(ctx, tx, collection, movieIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Get() method returns the collection with the given ID, without its movies.
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT
+			id, created_at, name, description,
+			(SELECT count(*) FROM collection_movies WHERE collection_id = collections.id), version
+		FROM collections
+		WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var collection Collection
+
+	err := m.DB.QueryRowContext(ctx, query, id, m.OrganizationID).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.Name,
+		&collection.Description,
+		&collection.MoviesCount,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+// The GetAll() method returns a paginated list of collections, optionally filtered by
+// name in the same way that MovieModel.GetAll() filters by title.
+func (m CollectionModel) GetAll(name string, filters Filters) ([]*Collection, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT
+			count(*) OVER(), id, created_at, name, description,
+			(SELECT count(*) FROM collection_movies WHERE collection_id = collections.id), version
+		FROM collections
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND organization_id = $2
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{name, m.OrganizationID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+
+		var collection Collection
+
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.Name,
+			&collection.Description,
+			&collection.MoviesCount,
+			&collection.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// The Update() method saves the changes to a collection's name and description, using
+// the version number to check for edit conflicts. If movieIDs isn't nil, then the
+// movies in the collection are replaced with them, in order.
+func (m CollectionModel) Update(collection *Collection, movieIDs []int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		UPDATE collections
+			SET name = $1, description = $2, version = version + 1
+		WHERE id = $3 AND version = $4 AND organization_id = $5
+		RETURNING version`
+
+	args := []any{collection.Name, collection.Description, collection.ID, collection.Version, m.OrganizationID}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if movieIDs != nil {
+		err = m.setMovies(ctx, tx, collection, movieIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method deletes a collection. The movies in it aren't affected.
+func (m CollectionModel) Delete(id int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM collections
+		WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The GetMovies() method returns the movies in a collection, in order.
+func (m CollectionModel) GetMovies(collectionID int64) ([]*Movie, error) {
+
+	query := `
+		SELECT
+			movies.id, movies.created_at, movies.title, movies.year, movies.runtime,
+			movies.genres, movies.version, movies.created_by, movies.average_rating,
+			movies.ratings_count, movies.poster_key, movies.poster_width, movies.poster_height,
+			collection_id, collection_name, collection_position, collection_movies_count
+		FROM collection_movies
+			INNER JOIN movies ON movies.id = collection_movies.movie_id` + collectionSummaryJoin + `
+		WHERE collection_movies.collection_id = $1 AND movies.organization_id = $2
+		ORDER BY collection_movies.position ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID, m.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+
+		var movie Movie
+		var poster Poster
+		var collection collectionSummaryColumns
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.CreatedBy,
+			&movie.AverageRating,
+			&movie.RatingsCount,
+			&poster.Key,
+			&poster.Width,
+			&poster.Height,
+			&collection.ID,
+			&collection.Name,
+			&collection.Position,
+			&collection.MoviesCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movie.setPoster(poster)
+		movie.setCollection(collection)
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// The setMovies() method replaces the movies in a collection, and updates its movies
+// count. Each movie's position is its 1-based index in movieIDs. It returns
+// ErrRecordNotFound if any of the movies don't exist in the organization, and
+// ErrMovieInOtherCollection if any of them are already in another collection.
+func (m CollectionModel) setMovies(ctx context.Context, tx *sql.Tx, collection *Collection, movieIDs []int64) error {
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM collection_movies WHERE collection_id = $1`, collection.ID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO collection_movies
+			(movie_id, collection_id, position)
+		SELECT
+			id, $1, array_position($2::bigint[], id)
+		FROM movies
+		WHERE id = ANY($2::bigint[]) AND organization_id = $3`
+
+	result, err := tx.ExecContext(ctx, query, collection.ID, pq.Array(movieIDs), m.OrganizationID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "collection_movies_pkey"`:
+			return ErrMovieInOtherCollection
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(movieIDs)) {
+		return ErrRecordNotFound
+	}
+
+	collection.MoviesCount = int32(rowsAffected)
+
+	return nil
+}
+
+// The collectionSummaryJoin clause adds the summary of the collection that each movie
+// is in to a query on the movies table, as the collection_id, collection_name,
+// collection_position and collection_movies_count columns. They're all NULL for movies
+// which aren't in a collection. We work out the position with row_number() rather than
+// using the stored position directly, because the stored positions have gaps when
+// movies are deleted.
+const collectionSummaryJoin = `
+		LEFT JOIN LATERAL (
+			SELECT
+				collections.id AS collection_id,
+				collections.name AS collection_name,
+				ranked.position AS collection_position,
+				ranked.movies_count AS collection_movies_count
+			FROM collection_movies
+				INNER JOIN collections ON collections.id = collection_movies.collection_id
+				INNER JOIN LATERAL (
+					SELECT
+						siblings.movie_id,
+						row_number() OVER (ORDER BY siblings.position) AS position,
+						count(*) OVER () AS movies_count
+					FROM collection_movies AS siblings
+					WHERE siblings.collection_id = collection_movies.collection_id
+				) AS ranked ON ranked.movie_id = collection_movies.movie_id
+			WHERE collection_movies.movie_id = movies.id
+		) AS collection_summary ON true`
+
+// The collectionSummaryColumns hold the columns added by collectionSummaryJoin while
+// they're scanned, since they can be NULL.
+type collectionSummaryColumns struct {
+	ID          sql.NullInt64
+	Name        sql.NullString
+	Position    sql.NullInt32
+	MoviesCount sql.NullInt32
+}
+
+// The setCollection() method attaches the collection summary which has been read from
+// the database to the movie. Its Collection field is left nil if it isn't in one, so
+// that it's omitted from the JSON.
+func (movie *Movie) setCollection(columns collectionSummaryColumns) {
+
+	if !columns.ID.Valid {
+		movie.Collection = nil
+		return
+	}
+
+	movie.Collection = &CollectionSummary{
+		ID:          columns.ID.Int64,
+		Name:        columns.Name.String,
+		Position:    columns.Position.Int32,
+		MoviesCount: columns.MoviesCount.Int32,
+	}
+}
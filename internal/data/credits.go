@@ -0,0 +1,234 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// Define a custom ErrDuplicateCredit error, which is returned when a movie already has
+// the same credit.
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// CreditRoles lists the roles that a person can have in a movie.
+var CreditRoles = []string{"director", "writer", "actor"}
+
+// The Credit struct records what a person did in a movie. Character is the name of the
+// character that an actor played, and BillingOrder is used to sort the credits of a
+// movie, lowest first. When we list the credits of a movie we include the person's
+// name, and when we list the credits of a person we include the movie's title and year.
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	MovieTitle   string `json:"movie_title,omitempty"`
+	MovieYear    int32  `json:"movie_year,omitempty"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name,omitempty"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+// The ValidateCredit() function checks a credit. Only actors play characters.
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.PermittedValue(credit.Role, CreditRoles...), "role", "must be director, writer or actor")
+	v.Check(credit.Character == "" || credit.Role == "actor", "character", "must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+// Define the CreditModel type. It only works with the credits of movies in the
+// organization in its OrganizationID field.
+type CreditModel struct {
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the CreditModel which works with the
+// credits of movies in the given organization.
+func (m CreditModel) ForOrganization(organizationID int64) CreditModel {
+	m.OrganizationID = organizationID
+	return m
+}
+
+// The Insert() method adds a credit to a movie. Both the movie and the person must be
+// in the model's organization; if either of them isn't, then nothing is inserted and
+// we return ErrRecordNotFound.
+func (m CreditModel) Insert(credit *Credit) error {
+
+	query := `
+		INSERT INTO movie_credits
+			(movie_id, person_id, role, character, billing_order)
+		SELECT
+			movies.id, people.id, $3, $4, $5
+		FROM movies, people
+		WHERE movies.id = $1 AND movies.organization_id = $6
+			AND people.id = $2 AND people.organization_id = $6
+		RETURNING
+			id`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder, m.OrganizationID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_credits_movie_id_person_id_role_character_key"`:
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The GetAllForMovie() method returns the credits of a movie in billing order, along
+// with the name of each person.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+
+	query := `
+		SELECT
+			movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+			movie_credits.role, movie_credits.character, movie_credits.billing_order
+		FROM movie_credits
+			INNER JOIN movies ON movies.id = movie_credits.movie_id
+			INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_credits.movie_id = $1 AND movies.organization_id = $2
+		ORDER BY movie_credits.billing_order ASC, movie_credits.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, m.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// The GetAllForPerson() method returns the credits of a person, newest movie first,
+// along with the title and year of each movie.
+func (m CreditModel) GetAllForPerson(personID int64) ([]*Credit, error) {
+
+	query := `
+		SELECT
+			movie_credits.id, movie_credits.movie_id, movies.title, movies.year,
+			movie_credits.person_id, movie_credits.role, movie_credits.character,
+			movie_credits.billing_order
+		FROM movie_credits
+			INNER JOIN movies ON movies.id = movie_credits.movie_id
+		WHERE movie_credits.person_id = $1 AND movies.organization_id = $2
+		ORDER BY movies.year DESC, movies.id ASC, movie_credits.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID, m.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.MovieTitle,
+			&credit.MovieYear,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// The Delete() method removes a credit from a movie.
+func (m CreditModel) Delete(movieID, id int64) error {
+
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movie_credits
+		USING movies
+		WHERE movie_credits.id = $1 AND movie_credits.movie_id = $2
+			AND movies.id = movie_credits.movie_id AND movies.organization_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
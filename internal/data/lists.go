@@ -0,0 +1,664 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Define the errors which the ListModel returns when a user tries to create a second
+// watchlist or favorites list, adds a movie which is already in the list, or reorders
+// a list without including exactly the movies that are in it.
+var (
+	ErrDuplicateListKind = errors.New("duplicate list kind")
+	ErrDuplicateListItem = errors.New("duplicate list item")
+	ErrListOrderMismatch = errors.New("list order mismatch")
+)
+
+// ListKinds lists the kinds of list that a user can have. Each user can have any
+// number of custom lists, but only one watchlist and one favorites list in each
+// organization.
+var (
+	ListKinds              = []string{"watchlist", "favorites", "custom"}
+	defaultListNamesByKind = map[string]string{"watchlist": "To watch", "favorites": "Favorites"}
+)
+
+// The List struct represents a user's list of movies. The Movies field is only filled
+// in when showing a single list, and holds the movies in the same shape as the
+// GET /v1/movies/:id endpoint.
+type List struct {
+	ID             int64     `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	UserID         int64     `json:"user_id"`
+	OrganizationID int64     `json:"-"`
+	Kind           string    `json:"kind"`
+	Name           string    `json:"name"`
+	Slug           string    `json:"slug"`
+	Public         bool      `json:"public"`
+	ItemsCount     int32     `json:"items_count"`
+	Movies         []*Movie  `json:"movies,omitempty"`
+	Version        int32     `json:"version"`
+}
+
+// The DefaultListName() function returns the name that a list of the given kind gets
+// if the user doesn't choose one, or an empty string for custom lists.
+func DefaultListName(kind string) string {
+	return defaultListNamesByKind[kind]
+}
+
+// The GenerateListSlug() function makes a slug for a list from its name, followed by a
+// few random characters so that lists with the same name get different slugs, like
+// "best-of-1999-3f9a1c0e".
+func GenerateListSlug(name string) (string, error) {
+
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+
+		if b.Len() >= 50 {
+			break
+		}
+	}
+
+	base := strings.Trim(b.String(), "-")
+	if base == "" {
+		base = "list"
+	}
+
+	randomBytes := make([]byte, 4)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base + "-" + hex.EncodeToString(randomBytes), nil
+}
+
+// The ValidateList() function checks the kind, name and slug of a list.
+func ValidateList(v *validator.Validator, list *List) {
+
+	v.Check(validator.PermittedValue(list.Kind, ListKinds...), "kind", "must be watchlist, favorites or custom")
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(list.Slug != "", "slug", "must be provided")
+	v.Check(len(list.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(list.Slug, validator.SlugRX), "slug", "must only contain lowercase letters, digits and dashes")
+}
+
+// Define the ListModel type. Like the MovieModel, a ListModel only works with the lists
+// in the organization in its OrganizationID field, so handlers must call
+// ForOrganization() to get one. The exception is GetBySlug(), which is used to share
+// public lists.
+type ListModel struct {
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the ListModel which works with the
+// lists in the given organization.
+func (m ListModel) ForOrganization(organizationID int64) ListModel {
+	m.OrganizationID = organizationID
+	return m
+}
+
+// The Insert() method creates a new, empty list.
+func (m ListModel) Insert(list *List) error {
+
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	query := `
+		INSERT INTO lists
+			(user_id, organization_id, kind, name, slug, public)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		RETURNING
+			id, created_at, updated_at, version`
+
+	list.OrganizationID = m.OrganizationID
+
+	args := []any{list.UserID, list.OrganizationID, list.Kind, list.Name, list.Slug, list.Public}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.UpdatedAt, &list.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "lists_slug_key"`:
+			return ErrDuplicateSlug
+		case err.Error() == `pq: duplicate key value violates unique constraint "lists_user_id_organization_id_kind_idx"`:
+			return ErrDuplicateListKind
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Get() method returns the list with the given ID. It doesn't check who owns the
+// list; that's up to the caller.
+func (m ListModel) Get(id int64) (*List, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT
+			id, created_at, updated_at, user_id, organization_id, kind, name, slug, public,
+			(SELECT count(*) FROM list_items WHERE list_id = lists.id), version
+		FROM lists
+		WHERE id = $1 AND organization_id = $2`
+
+	return m.get(query, id, m.OrganizationID)
+}
+
+// The GetBySlug() method returns the list with the given slug, in any organization. The
+// caller must check that the user is allowed to see a list in its organization.
+func (m ListModel) GetBySlug(slug string) (*List, error) {
+
+	query := `
+		SELECT
+			id, created_at, updated_at, user_id, organization_id, kind, name, slug, public,
+			(SELECT count(*) FROM list_items WHERE list_id = lists.id), version
+		FROM lists
+		WHERE slug = $1`
+
+	return m.get(query, slug)
+}
+
+func (m ListModel) get(query string, args ...any) (*List, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list List
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+		&list.UserID,
+		&list.OrganizationID,
+		&list.Kind,
+		&list.Name,
+		&list.Slug,
+		&list.Public,
+		&list.ItemsCount,
+		&list.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+// The GetAllForUser() method returns a paginated list of a user's lists, without their
+// movies.
+func (m ListModel) GetAllForUser(userID int64, filters Filters) ([]*List, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT
+			count(*) OVER(), id, created_at, updated_at, user_id, organization_id, kind, name,
+			slug, public,
+			(SELECT count(*) FROM list_items WHERE list_id = lists.id), version
+		FROM lists
+		WHERE user_id = $1 AND organization_id = $2
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{userID, m.OrganizationID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	lists := []*List{}
+
+	for rows.Next() {
+
+		var list List
+
+		err := rows.Scan(
+			&totalRecords,
+			&list.ID,
+			&list.CreatedAt,
+			&list.UpdatedAt,
+			&list.UserID,
+			&list.OrganizationID,
+			&list.Kind,
+			&list.Name,
+			&list.Slug,
+			&list.Public,
+			&list.ItemsCount,
+			&list.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return lists, metadata, nil
+}
+
+// The GetAllOwnedBy() method returns all of a user's lists in the organization, without
+// their movies, in the order they were created.
+func (m ListModel) GetAllOwnedBy(userID int64) ([]*List, error) {
+
+	query := `
+		SELECT
+			id, created_at, updated_at, user_id, organization_id, kind, name, slug, public,
+			(SELECT count(*) FROM list_items WHERE list_id = lists.id), version
+		FROM lists
+		WHERE user_id = $1 AND organization_id = $2
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, m.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	lists := []*List{}
+
+	for rows.Next() {
+
+		var list List
+
+		err := rows.Scan(
+			&list.ID,
+			&list.CreatedAt,
+			&list.UpdatedAt,
+			&list.UserID,
+			&list.OrganizationID,
+			&list.Kind,
+			&list.Name,
+			&list.Slug,
+			&list.Public,
+			&list.ItemsCount,
+			&list.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// The Update() method saves changes to the name, slug and visibility of a list, using
+// the version number to check for edit conflicts.
+func (m ListModel) Update(list *List) error {
+
+	query := `
+		UPDATE lists
+			SET name = $1, slug = $2, public = $3, updated_at = NOW(), version = version + 1
+		WHERE id = $4 AND version = $5 AND organization_id = $6
+		RETURNING updated_at, version`
+
+	args := []any{list.Name, list.Slug, list.Public, list.ID, list.Version, m.OrganizationID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.UpdatedAt, &list.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "lists_slug_key"`:
+			return ErrDuplicateSlug
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delete() method deletes a list and its items.
+func (m ListModel) Delete(id int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM lists
+		WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The GetMovies() method returns the movies in a list, in order. Note that this isn't
+// limited to the model's organization, so that it can be used for public lists; the
+// movies in a list always belong to the list's own organization anyway.
+func (m ListModel) GetMovies(listID int64) ([]*Movie, error) {
+
+	query := `
+		SELECT
+			movies.id, movies.created_at, movies.title, movies.year, movies.runtime,
+			movies.genres, movies.version, movies.created_by, movies.average_rating,
+			movies.ratings_count, movies.poster_key, movies.poster_width, movies.poster_height,
+			collection_id, collection_name, collection_position, collection_movies_count
+		FROM list_items
+			INNER JOIN movies ON movies.id = list_items.movie_id` + collectionSummaryJoin + `
+		WHERE list_items.list_id = $1
+		ORDER BY list_items.position ASC, list_items.added_at ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+
+		var movie Movie
+		var poster Poster
+		var collection collectionSummaryColumns
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.CreatedBy,
+			&movie.AverageRating,
+			&movie.RatingsCount,
+			&poster.Key,
+			&poster.Width,
+			&poster.Height,
+			&collection.ID,
+			&collection.Name,
+			&collection.Position,
+			&collection.MoviesCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movie.setPoster(poster)
+		movie.setCollection(collection)
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// The AddMovie() method adds a movie to the end of a list. It returns
+// ErrRecordNotFound if there's no such movie in the list's organization, and
+// ErrDuplicateListItem if the movie is already in the list.
+func (m ListModel) AddMovie(listID, movieID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = m.lockList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO list_items
+			(list_id, movie_id, position)
+		SELECT
+			lists.id, movies.id,
+			COALESCE((SELECT MAX(position) FROM list_items WHERE list_id = lists.id), 0) + 1
+		FROM lists, movies
+		WHERE lists.id = $1 AND movies.id = $2 AND movies.organization_id = lists.organization_id`
+
+	result, err := tx.ExecContext(ctx, query, listID, movieID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "list_items_pkey"`:
+			return ErrDuplicateListItem
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = touchList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The RemoveMovie() method removes a movie from a list. The positions of the remaining
+// movies are left alone, since only their order matters.
+func (m ListModel) RemoveMovie(listID, movieID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = m.lockList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM list_items
+		WHERE list_id = $1 AND movie_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, listID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = touchList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Reorder() method puts the movies in a list into the given order. The movieIDs
+// slice must contain each of the movies in the list exactly once; otherwise nothing
+// is changed and ErrListOrderMismatch is returned.
+func (m ListModel) Reorder(listID int64, movieIDs []int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = m.lockList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	var count int
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM list_items WHERE list_id = $1`, listID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count != len(movieIDs) {
+		return ErrListOrderMismatch
+	}
+
+	// The array_position() function returns the 1-based index of each movie ID in the
+	// array, which becomes its new position.
+	query := `
+		UPDATE list_items
+			SET position = array_position($2::bigint[], movie_id)
+		WHERE list_id = $1 AND movie_id = ANY($2::bigint[])`
+
+	result, err := tx.ExecContext(ctx, query, listID, pq.Array(movieIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(movieIDs)) {
+		return ErrListOrderMismatch
+	}
+
+	err = touchList(ctx, tx, listID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The lockList() method locks the row for a list in the model's organization until
+// the end of the transaction, returning ErrRecordNotFound if there's no such list.
+// Like ReviewModel.lockMovie(), this makes concurrent changes to the same list take
+// turns, so that the positions of its items stay consistent.
+func (m ListModel) lockList(ctx context.Context, tx *sql.Tx, listID int64) error {
+
+	query := `
+		SELECT id
+		FROM lists
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE`
+
+	var id int64
+
+	err := tx.QueryRowContext(ctx, query, listID, m.OrganizationID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The touchList() function records that the items in a list have changed. It doesn't
+// change the list's version number, which only covers its name, slug and visibility.
+func touchList(ctx context.Context, tx *sql.Tx, listID int64) error {
+
+	_, err := tx.ExecContext(ctx, `UPDATE lists SET updated_at = NOW() WHERE id = $1`, listID)
+	return err
+}
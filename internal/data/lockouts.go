@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// The Lockout struct holds the failed login attempts for an email address, and the time
+// until which logins for it are locked (if they are). Lockouts are identified by a hash
+// of the email address rather than by user ID, so that email addresses which don't
+// belong to an account are locked in exactly the same way as those which do. Otherwise
+// the difference would tell an attacker which email addresses have accounts.
+type Lockout struct {
+	EmailHash      []byte
+	FailedAttempts int
+	LastFailedAt   time.Time
+	LockedUntil    *time.Time
+}
+
+// The IsLocked() method reports whether the account is locked at the given time.
+func (l *Lockout) IsLocked(t time.Time) bool {
+	return l.LockedUntil != nil && l.LockedUntil.After(t)
+}
+
+// Define the LockoutModel type.
+type LockoutModel struct {
+	DB *sql.DB
+}
+
+// The lockoutEmailHash() function returns the SHA-256 hash of the lower case email
+// address. Email addresses are case-insensitive, so we lower case them first.
+func lockoutEmailHash(email string) []byte {
+	hash := sha256.Sum256([]byte(strings.ToLower(email)))
+	return hash[:]
+}
+
+// The GetForEmail() method returns the lockout record for a specific email address, or
+// ErrRecordNotFound if there haven't been any failed login attempts for it.
+func (m LockoutModel) GetForEmail(email string) (*Lockout, error) {
+
+	query := `
+		SELECT 
+			email_hash, failed_attempts, last_failed_at, locked_until
+		FROM account_lockouts
+		WHERE email_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lockout Lockout
+
+	err := m.DB.QueryRowContext(ctx, query, lockoutEmailHash(email)).Scan(
+		&lockout.EmailHash,
+		&lockout.FailedAttempts,
+		&lockout.LastFailedAt,
+		&lockout.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &lockout, nil
+}
+
+// The RecordFailure() method records a failed login attempt for an email address, whether
+// or not it belongs to an account. Failures which
+// are older than the window are forgotten, so that a handful of typos spread over
+// several months doesn't eventually lock the account. If the number of failures reaches
+// maxAttempts then the account is locked for lockDuration, the failure count is reset,
+// and the returned bool is true.
+func (m LockoutModel) RecordFailure(email string, maxAttempts int, window, lockDuration time.Duration) (*Lockout, bool, error) {
+
+	now := time.Now()
+
+	query := `
+		INSERT INTO account_lockouts 
+			(email_hash, failed_attempts, last_failed_at)
+		VALUES 
+			($1, 1, $2)
+		ON CONFLICT (email_hash) DO UPDATE
+			SET failed_attempts = CASE 
+					WHEN account_lockouts.last_failed_at < $3 THEN 1
+					ELSE account_lockouts.failed_attempts + 1
+				END,
+				last_failed_at = EXCLUDED.last_failed_at
+		RETURNING email_hash, failed_attempts, last_failed_at, locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	emailHash := lockoutEmailHash(email)
+
+	var lockout Lockout
+
+	err := m.DB.QueryRowContext(ctx, query, emailHash, now, now.Add(-window)).Scan(
+		&lockout.EmailHash,
+		&lockout.FailedAttempts,
+		&lockout.LastFailedAt,
+		&lockout.LockedUntil,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if lockout.FailedAttempts < maxAttempts {
+		return &lockout, false, nil
+	}
+
+	// Lock the account. The WHERE clause makes sure that only one request locks it (and
+	// sends the notification email) if several failed attempts arrive at the same time.
+	query = `
+		UPDATE account_lockouts
+		SET locked_until = $2, failed_attempts = 0
+		WHERE email_hash = $1 AND failed_attempts >= $3
+		RETURNING locked_until`
+
+	err = m.DB.QueryRowContext(ctx, query, emailHash, now.Add(lockDuration), maxAttempts).Scan(&lockout.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &lockout, false, nil
+		default:
+			return nil, false, err
+		}
+	}
+
+	lockout.FailedAttempts = 0
+
+	return &lockout, true, nil
+}
+
+// The DeleteForEmail() method clears the failed login attempts and any lock for an
+// email address. We call it after a successful login or password reset.
+func (m LockoutModel) DeleteForEmail(email string) error {
+
+	query := `
+		DELETE FROM account_lockouts
+		WHERE email_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, lockoutEmailHash(email))
+	return err
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"time"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -10,24 +11,51 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrNoOrganization = errors.New("no organization")
 )
 
 // Create a Models struct which wraps the MovieModel. We'll add other models to this,
 // like a UserModel and PermissionModel.
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies        MovieModel
+	Users         UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	Revocations   RevocationModel
+	TOTP          TOTPModel
+	Lockouts      LockoutModel
+	Roles         RoleModel
+	AuditEvents   AuditEventModel
+	Organizations OrganizationModel
+	Reviews       ReviewModel
+	People        PersonModel
+	Credits       CreditModel
+	Lists         ListModel
+	Collections   CollectionModel
 }
 
 // Fo ease of use, we also add a New() method which returns a models struct containing
-// the initialized MovieModel.
-func NewModels(db *sql.DB) Models {
+// the initialized MovieModel. The permissionsCacheTTL parameter controls how long users'
+// permissions are cached for; a value of zero disables the cache.
+func NewModels(db *sql.DB, permissionsCacheTTL time.Duration) Models {
+
+	permissionCache := NewPermissionCache(permissionsCacheTTL)
+
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:        MovieModel{DB: db},
+		Users:         UserModel{DB: db},
+		Tokens:        TokenModel{DB: db},
+		Permissions:   PermissionModel{DB: db, Cache: permissionCache},
+		Revocations:   RevocationModel{DB: db},
+		TOTP:          TOTPModel{DB: db},
+		Lockouts:      LockoutModel{DB: db},
+		Roles:         RoleModel{DB: db, Cache: permissionCache},
+		AuditEvents:   AuditEventModel{DB: db},
+		Organizations: OrganizationModel{DB: db},
+		Reviews:       ReviewModel{DB: db},
+		People:        PersonModel{DB: db},
+		Credits:       CreditModel{DB: db},
+		Lists:         ListModel{DB: db},
+		Collections:   CollectionModel{DB: db},
 	}
 }
@@ -25,6 +25,35 @@ type Movie struct {
 	Version int32    `json:"version"`
 	// The version number starts at 1 and will be incremented each
 	// time the movie information is updated
+
+	// The ID of the user who created the movie. This is nil for movies created before
+	// we started recording it, and for movies whose creator has deleted their account.
+	CreatedBy *int64 `json:"created_by,omitempty"`
+
+	// The average review score and the number of reviews. These are kept up to date by
+	// the ReviewModel, and can't be changed directly.
+	AverageRating float64 `json:"average_rating"`
+	RatingsCount  int32   `json:"ratings_count"`
+
+	// The collection that the movie is in, if any.
+	Collection *CollectionSummary `json:"collection,omitempty"`
+
+	// The movie's poster, if it has one. Posters are uploaded separately from the rest
+	// of the movie's data, using UpdatePoster().
+	Poster *Poster `json:"poster,omitempty"`
+}
+
+// The MovieEditor struct describes the user who is changing or deleting a movie. If
+// OwnOnly is true, then the user is only allowed to change the movies that they created
+// themselves.
+type MovieEditor struct {
+	UserID  int64
+	OwnOnly bool
+}
+
+// The CanEdit() method reports whether the editor is allowed to change the movie.
+func (e MovieEditor) CanEdit(movie *Movie) bool {
+	return !e.OwnOnly || (movie.CreatedBy != nil && *movie.CreatedBy == e.UserID)
 }
 
 // Validate
@@ -44,26 +73,46 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 }
 
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
+//
+// Every movie belongs to an organization, and a MovieModel only ever reads or changes
+// the movies of the organization in its OrganizationID field, which every query
+// filters on. The MovieModel in Models has an OrganizationID of zero, which doesn't
+// match any organization, so handlers must call ForOrganization() to get a MovieModel
+// for the organization that the user is working in. If a handler forgets, it will see
+// an empty catalog rather than every organization's movies.
 type MovieModel struct {
-	DB *sql.DB
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the MovieModel which works with the
+// movies of the given organization.
+func (m MovieModel) ForOrganization(organizationID int64) MovieModel {
+	m.OrganizationID = organizationID
+	return m
 }
 
 // The Insert() method accepts a pointer to a movie struct, which should contain
 // the data for the new record.
 func (m MovieModel) Insert(movie *Movie) error {
 
+	// Movies can't be created outside of an organization.
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
 	query := `
 		INSERT INTO movies 
-			(title, year, runtime, genres)
+			(title, year, runtime, genres, created_by, organization_id)
 		VALUES 
-			($1, $2, $3, $4)
+			($1, $2, $3, $4, $5, $6)
 		RETURNING 
 			id, created_at, version`
 
 	// Create an args slice containing the values for the placeholder paras from
 	// the movie struct. Declaring this slice immediately next to our SQL query helps to
 	// make it nice and clear *what values are bring used where* in the query
-	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.CreatedBy, m.OrganizationID}
 
 	// Use the QueryRow() method to execute the SQL query on our connection pool,
 	// passing in the args slice as a variadic para and scanning the system
@@ -86,12 +135,16 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	// update the query to return pg_sleeep(8) as the first value
 	query := `
 		SELECT 
-			id, created_at, title, year, runtime, genres, version
-		FROM movies
-		WHERE id = $1`
+			id, created_at, title, year, runtime, genres, version, created_by,
+			average_rating, ratings_count, poster_key, poster_width, poster_height,
+			collection_id, collection_name, collection_position, collection_movies_count
+		FROM movies` + collectionSummaryJoin + `
+		WHERE id = $1 AND organization_id = $2`
 
 	// Declare a Movie struct to hold data returned by the query
 	var movie Movie
+	var poster Poster
+	var collection collectionSummaryColumns
 
 	// Use the context.WithTimeout() function to create a context.Context which carries a
 	// 3-second timeout deadline. Note that we're using the empty context.Background()
@@ -104,7 +157,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// Use the QueryRowContext() method to execute the query, passing in the context
 	// with the deadline as the first argument
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, m.OrganizationID).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -112,6 +165,16 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.CreatedBy,
+		&movie.AverageRating,
+		&movie.RatingsCount,
+		&poster.Key,
+		&poster.Width,
+		&poster.Height,
+		&collection.ID,
+		&collection.Name,
+		&collection.Position,
+		&collection.MoviesCount,
 	)
 
 	// Handle any errors. If there was no matching movie found, scan() will return
@@ -126,17 +189,23 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
+	movie.setPoster(poster)
+	movie.setCollection(collection)
+
 	// Otherwise, return a pointer to the Movie struct
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// The Update() method saves the changes to a movie. As well as checking the version
+// number, the query makes sure that editors who may only change their own movies can't
+// change anybody else's, even if a handler forgets to check.
+func (m MovieModel) Update(movie *Movie, editor MovieEditor) error {
 
 	// Add the 'AND version = $6' clause to the SQL query
 	query := `
 		UPDATE movies
 			SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND version = $6 AND (created_by = $7 OR NOT $8) AND organization_id = $9
 		RETURNING version`
 
 	// Create an args slice containing the values for the placeholder parameters.
@@ -147,6 +216,9 @@ func (m MovieModel) Update(movie *Movie) error {
 		pq.Array(movie.Genres),
 		movie.ID,
 		movie.Version, // Add the expected movie version
+		editor.UserID,
+		editor.OwnOnly,
+		m.OrganizationID,
 	}
 
 	// Create a context with a 3-second timeout
@@ -170,8 +242,9 @@ func (m MovieModel) Update(movie *Movie) error {
 
 }
 
-// .
-func (m MovieModel) Delete(id int64) error {
+// The Delete() method deletes a movie. Like Update(), it only deletes a movie created by
+// somebody else if the editor is allowed to change any movie.
+func (m MovieModel) Delete(id int64, editor MovieEditor) error {
 
 	// .
 	if id < 1 {
@@ -181,14 +254,14 @@ func (m MovieModel) Delete(id int64) error {
 	// .
 	query := `
 		DELETE FROM movies
-		WHERE id = $1`
+		WHERE id = $1 AND (created_by = $2 OR NOT $3) AND organization_id = $4`
 
 	// Create a context with a 3-second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	// The Exec() method returns a sql.Result object.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, editor.UserID, editor.OwnOnly, m.OrganizationID)
 	if err != nil {
 		return err
 	}
@@ -215,7 +288,10 @@ func (m MovieModel) Delete(id int64) error {
 // using them right now, we've set this up to accept the various filter parameters as
 // arguments.
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// If personID isn't zero, then only the movies which credit that person (in any role)
+// are returned, and likewise if collectionID isn't zero then only the movies in that
+// collection are returned.
+func (m MovieModel) GetAll(title string, genres []string, personID, collectionID int64, filters Filters) ([]*Movie, Metadata, error) {
 
 	// Add an ORDER BY clause and interpolate the sort column and direction. Importantly
 	// notice that we also include a secondary sort on the movie ID to ensure a
@@ -228,10 +304,15 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// (filtered) records.
 	query := fmt.Sprintf(`
 		SELECT 
-			count(*) OVER(), id, created_at, title, year, runtime, genres, version
-		FROM movies
+			count(*) OVER(), id, created_at, title, year, runtime, genres, version, created_by,
+			average_rating, ratings_count, poster_key, poster_width, poster_height,
+			collection_id, collection_name, collection_position, collection_movies_count
+		FROM movies`+collectionSummaryJoin+`
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (genres @> $2 OR $2 = '{}')
+		AND (EXISTS (SELECT 1 FROM movie_credits WHERE movie_id = movies.id AND person_id = $6) OR $6 = 0)
+		AND (EXISTS (SELECT 1 FROM collection_movies WHERE movie_id = movies.id AND collection_id = $7) OR $7 = 0)
+		AND organization_id = $5
 		ORDER BY %s %s, id ASC
 		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 
@@ -243,7 +324,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// values for the placeholders in a slice. Notice here how we call the limit() and
 	// offset() methods on the Filters struct to get the appropriate values for the
 	// LIMIT and OFFSET clauses.
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []any{title, pq.Array(genres), filters.limit(), filters.offset(), m.OrganizationID, personID, collectionID}
 
 	// Pass the args slice
 	rows, err := m.DB.QueryContext(ctx, query, args...)
@@ -267,6 +348,8 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 
 		// Initialize an empty Movie struct to hold the data for an individual movie
 		var movie Movie
+		var poster Poster
+		var collection collectionSummaryColumns
 
 		// Scan the values from the row into the Movie struct.
 		err := rows.Scan(
@@ -279,12 +362,25 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.CreatedBy,
+			&movie.AverageRating,
+			&movie.RatingsCount,
+			&poster.Key,
+			&poster.Width,
+			&poster.Height,
+			&collection.ID,
+			&collection.Name,
+			&collection.Position,
+			&collection.MoviesCount,
 		)
 		if err != nil {
 			// Update this to return an empty Metadata struct.
 			return nil, Metadata{}, err
 		}
 
+		movie.setPoster(poster)
+		movie.setCollection(collection)
+
 		// Add the Movie struct to the slice
 		movies = append(movies, &movie)
 	}
@@ -303,3 +399,70 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// Include the metadata struct when returning
 	return movies, metadata, nil
 }
+
+// The GetAllCreatedBy() method returns all of the movies in the organization created by
+// a specific user, in the order they were created.
+func (m MovieModel) GetAllCreatedBy(userID int64) ([]*Movie, error) {
+
+	query := `
+		SELECT 
+			id, created_at, title, year, runtime, genres, version, created_by,
+			average_rating, ratings_count, poster_key, poster_width, poster_height,
+			collection_id, collection_name, collection_position, collection_movies_count
+		FROM movies` + collectionSummaryJoin + `
+		WHERE created_by = $1 AND organization_id = $2
+		ORDER BY id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, m.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+
+		var movie Movie
+		var poster Poster
+		var collection collectionSummaryColumns
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.CreatedBy,
+			&movie.AverageRating,
+			&movie.RatingsCount,
+			&poster.Key,
+			&poster.Width,
+			&poster.Height,
+			&collection.ID,
+			&collection.Name,
+			&collection.Position,
+			&collection.MoviesCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movie.setPoster(poster)
+		movie.setCollection(collection)
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
@@ -0,0 +1,324 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// Define a custom ErrDuplicateSlug error, which is returned when an organization is
+// created with a slug that is already in use.
+var ErrDuplicateSlug = errors.New("duplicate slug")
+
+// The Organization struct represents one of the companies that we host a catalog for.
+// Every movie belongs to exactly one organization, and users can only see the movies of
+// the organization they are currently working in.
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Version   int       `json:"-"`
+}
+
+// The ValidateOrganization() function checks the name and slug of an organization.
+func ValidateOrganization(v *validator.Validator, organization *Organization) {
+
+	v.Check(organization.Name != "", "name", "must be provided")
+	v.Check(len(organization.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(organization.Slug != "", "slug", "must be provided")
+	v.Check(len(organization.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(organization.Slug, validator.SlugRX), "slug", "must only contain lowercase letters, digits and dashes")
+}
+
+// Define the OrganizationModel type.
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// The Insert() method creates a new organization.
+func (m OrganizationModel) Insert(organization *Organization) error {
+
+	query := `
+		INSERT INTO organizations
+			(name, slug)
+		VALUES
+			($1, $2)
+		RETURNING
+			id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, organization.Name, organization.Slug).Scan(&organization.ID, &organization.CreatedAt, &organization.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "organizations_slug_key"`:
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Get() method returns the organization with the given ID.
+func (m OrganizationModel) Get(id int64) (*Organization, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT
+			id, created_at, name, slug, version
+		FROM organizations
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var organization Organization
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&organization.ID,
+		&organization.CreatedAt,
+		&organization.Name,
+		&organization.Slug,
+		&organization.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &organization, nil
+}
+
+// The GetBySlug() method returns the organization with the given slug.
+func (m OrganizationModel) GetBySlug(slug string) (*Organization, error) {
+
+	query := `
+		SELECT
+			id, created_at, name, slug, version
+		FROM organizations
+		WHERE slug = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var organization Organization
+
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(
+		&organization.ID,
+		&organization.CreatedAt,
+		&organization.Name,
+		&organization.Slug,
+		&organization.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &organization, nil
+}
+
+// The GetAll() method returns a paginated list of all organizations.
+func (m OrganizationModel) GetAll(filters Filters) ([]*Organization, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT
+			count(*) OVER(), id, created_at, name, slug, version
+		FROM organizations
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	organizations := []*Organization{}
+
+	for rows.Next() {
+
+		var organization Organization
+
+		err := rows.Scan(
+			&totalRecords,
+			&organization.ID,
+			&organization.CreatedAt,
+			&organization.Name,
+			&organization.Slug,
+			&organization.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		organizations = append(organizations, &organization)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return organizations, metadata, nil
+}
+
+// The GetAllForUser() method returns the organizations that a user is a member of, in
+// the order that they joined them.
+func (m OrganizationModel) GetAllForUser(userID int64) ([]*Organization, error) {
+
+	query := `
+		SELECT
+			organizations.id, organizations.created_at, organizations.name,
+			organizations.slug, organizations.version
+		FROM organizations
+			INNER JOIN organization_members
+			ON organization_members.organization_id = organizations.id
+		WHERE organization_members.user_id = $1
+		ORDER BY organization_members.created_at ASC, organizations.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	organizations := []*Organization{}
+
+	for rows.Next() {
+
+		var organization Organization
+
+		err := rows.Scan(
+			&organization.ID,
+			&organization.CreatedAt,
+			&organization.Name,
+			&organization.Slug,
+			&organization.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		organizations = append(organizations, &organization)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return organizations, nil
+}
+
+// The IsMember() method reports whether a user is a member of an organization.
+func (m OrganizationModel) IsMember(organizationID, userID int64) (bool, error) {
+
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM organization_members
+			WHERE organization_id = $1 AND user_id = $2
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var member bool
+
+	err := m.DB.QueryRowContext(ctx, query, organizationID, userID).Scan(&member)
+	return member, err
+}
+
+// The AddMember() method adds a user to an organization. Adding a user who is already a
+// member does nothing.
+func (m OrganizationModel) AddMember(organizationID, userID int64) error {
+
+	query := `
+		INSERT INTO organization_members
+			(organization_id, user_id)
+		VALUES
+			($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, organizationID, userID)
+	return err
+}
+
+// The RemoveMember() method removes a user from an organization. As well as deleting
+// the membership, it deletes the user's tokens for the organization in the same
+// transaction, so that they can't carry on using the organization's catalog with a
+// token that they already have.
+func (m OrganizationModel) RemoveMember(organizationID, userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, organizationID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	query = `
+		DELETE FROM tokens
+		WHERE organization_id = $1 AND user_id = $2`
+
+	_, err = tx.ExecContext(ctx, query, organizationID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// The Person struct represents somebody who worked on a movie, like a director, writer
+// or actor. What they did on each movie is recorded in a Credit.
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// The ValidatePerson() function checks the name and biography of a person.
+func ValidatePerson(v *validator.Validator, person *Person) {
+
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+// Define the PersonModel type. Like the MovieModel, a PersonModel only works with the
+// people in the organization in its OrganizationID field, so handlers must call
+// ForOrganization() to get one.
+type PersonModel struct {
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the PersonModel which works with the
+// people in the given organization.
+func (m PersonModel) ForOrganization(organizationID int64) PersonModel {
+	m.OrganizationID = organizationID
+	return m
+}
+
+// The Insert() method adds a new person to the organization.
+func (m PersonModel) Insert(person *Person) error {
+
+	if m.OrganizationID < 1 {
+		return ErrNoOrganization
+	}
+
+	query := `
+		INSERT INTO people
+			(organization_id, name, biography)
+		VALUES
+			($1, $2, $3)
+		RETURNING
+			id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, m.OrganizationID, person.Name, person.Biography).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+// The Get() method returns the person with the given ID.
+func (m PersonModel) Get(id int64) (*Person, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT
+			id, created_at, name, biography, version
+		FROM people
+		WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var person Person
+
+	err := m.DB.QueryRowContext(ctx, query, id, m.OrganizationID).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// The GetAll() method returns a paginated list of people, optionally filtered by name
+// in the same way that MovieModel.GetAll() filters by title.
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT
+			count(*) OVER(), id, created_at, name, biography, version
+		FROM people
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND organization_id = $2
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{name, m.OrganizationID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// The Update() method saves the changes to a person, using the version number to check
+// for edit conflicts.
+func (m PersonModel) Update(person *Person) error {
+
+	query := `
+		UPDATE people
+			SET name = $1, biography = $2, version = version + 1
+		WHERE id = $3 AND version = $4 AND organization_id = $5
+		RETURNING version`
+
+	args := []any{person.Name, person.Biography, person.ID, person.Version, m.OrganizationID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delete() method deletes a person, along with all of their credits.
+func (m PersonModel) Delete(id int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM people
+		WHERE id = $1 AND organization_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, m.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -3,39 +3,183 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
 )
 
+// PermissionCatalog lists every permission code that the application checks for. The
+// permissions table must contain exactly these codes, plus any wildcard codes (see
+// below) which cover them; we check this when the application starts.
+var PermissionCatalog = []string{
+	"movies:read",
+	"movies:write",
+	"movies:delete",
+	"movies:write:own",
+	"movies:delete:own",
+	"reviews:write",
+	"users:admin",
+	"users:impersonate",
+	"audit:read",
+}
+
+// impliedPermissions maps a permission code to the more specific codes that it
+// implies. This lets us split a permission into finer-grained ones without taking
+// anything away from the users who already have it. Implications are transitive.
+var impliedPermissions = map[string][]string{
+	"movies:write":     {"movies:delete", "movies:write:own"},
+	"movies:delete":    {"movies:delete:own"},
+	"movies:write:own": {"movies:delete:own"},
+}
+
 // Define a Permissions slice, which we will use to hold the permission codes (like
 // "movies:read" and "movies:write") for a single user.
 type Permissions []string
 
-// Add a helper method to check whether the Permissions slice contains a specific
-// permission code.
+// Add a helper method to check whether the Permissions slice grants a specific
+// permission code. As well as exact matches, a code of "*" grants every permission, a
+// code ending in ":*" (like "movies:*") grants every permission with that prefix, and a
+// code grants all of the codes that it implies.
 func (p Permissions) Include(code string) bool {
 
-	return slices.Contains(p, code)
+	return slices.ContainsFunc(p, func(granted string) bool {
+		return grants(granted, code)
+	})
+}
+
+// The grants() function reports whether the granted permission code grants the code.
+func grants(granted, code string) bool {
+
+	switch {
+	case granted == code || granted == "*":
+		return true
+	case strings.HasSuffix(granted, ":*"):
+		return strings.HasPrefix(code, strings.TrimSuffix(granted, "*"))
+	}
+
+	for _, implied := range impliedPermissions[granted] {
+		if grants(implied, code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The Intersect() method returns the codes in the PermissionCatalog which are granted
+// by both p and other. Because wildcards and implied codes mean that two different
+// codes can grant the same permission, we can't simply compare the codes themselves.
+func (p Permissions) Intersect(other Permissions) Permissions {
+
+	var permissions Permissions
+
+	for _, code := range PermissionCatalog {
+		if p.Include(code) && other.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	return permissions
 }
 
-// Define the PermissionModel type
+// The ValidatePermissionCatalog() function checks the permission codes from the
+// permissions table against the PermissionCatalog. Every code in the catalog must be in
+// the table, and every code in the table must either be in the catalog or be a wildcard
+// which grants at least one code in the catalog.
+func ValidatePermissionCatalog(codes Permissions) error {
+
+	var problems []string
+
+	for _, code := range PermissionCatalog {
+		if !slices.Contains(codes, code) {
+			problems = append(problems, fmt.Sprintf("permission %q is missing from the permissions table", code))
+		}
+	}
+
+	for _, code := range codes {
+		if slices.Contains(PermissionCatalog, code) {
+			continue
+		}
+
+		wildcard := code == "*" || strings.HasSuffix(code, ":*")
+
+		if !wildcard || !slices.ContainsFunc(PermissionCatalog, func(c string) bool { return grants(code, c) }) {
+			problems = append(problems, fmt.Sprintf("permission %q in the permissions table is unknown", code))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Define the PermissionModel type. If Cache is not nil, then GetAllForUser() caches
+// its results in it.
 type PermissionModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Cache *PermissionCache
 }
 
 // The GetAllForUser() method returns all permission codes for a specific user in a
-// Permissions slice.
+// Permissions slice. This includes the permissions which have been granted to the user
+// directly, and the permissions of all of their roles. The recursive common table
+// expression walks up the role hierarchy to find every role that the user's roles
+// inherit from; because it uses UNION rather than UNION ALL, each role is only visited
+// once, so a cycle in the hierarchy can't cause an infinite loop.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
+	var generation uint64
+
+	if m.Cache != nil {
+		permissions, gen, found := m.Cache.get(userID)
+		if found {
+			return permissions, nil
+		}
+		generation = gen
+	}
+
+	permissions, err := m.getAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Cache != nil {
+		m.Cache.set(userID, permissions, generation)
+	}
+
+	return permissions, nil
+}
+
+// The getAllForUser() method runs the query for GetAllForUser(), bypassing the cache.
+func (m PermissionModel) getAllForUser(userID int64) (Permissions, error) {
+
 	query := `
+		WITH RECURSIVE user_roles AS (
+			SELECT role_id 
+			FROM users_roles 
+			WHERE user_id = $1
+			UNION
+			SELECT roles_inheritance.parent_id
+			FROM roles_inheritance
+				INNER JOIN user_roles ON roles_inheritance.role_id = user_roles.role_id
+		)
 		SELECT 
 			permissions.code
 		FROM permissions
 			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-			INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1`
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT 
+			permissions.code
+		FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN user_roles ON roles_permissions.role_id = user_roles.role_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
@@ -85,5 +229,119 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+
+	return nil
+}
+
+// The RemoveForUser() method removes the provided permission codes from a specific
+// user. Codes which the user doesn't have are ignored.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+
+	query := `
+		DELETE FROM users_permissions
+		WHERE user_id = $1 AND permission_id IN (
+			SELECT permissions.id 
+			FROM permissions 
+			WHERE permissions.code = ANY($2)
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+
+	return nil
+}
+
+// The SetForUser() method replaces all of a user's permissions with the provided
+// permission codes. We do this in a transaction, so that the user is never left without
+// any permissions part-way through.
+func (m PermissionModel) SetForUser(userID int64, codes ...string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_permissions WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO users_permissions
+			SELECT $1, permissions.id 
+			FROM permissions 
+			WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+
+	return nil
+}
+
+// The GetAll() method returns every permission code in the permissions table.
+func (m PermissionModel) GetAll() (Permissions, error) {
+
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
 }
@@ -0,0 +1,141 @@
+package data
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The PermissionCache type caches the permissions returned by
+// PermissionModel.GetAllForUser(), keyed by user ID, so that we don't have to run the
+// query on every request. Entries expire after the TTL, and are removed straight away
+// whenever a user's permissions are changed.
+type PermissionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[int64]permissionCacheEntry
+
+	// The generation is incremented every time anything is invalidated. We use it to
+	// avoid caching permissions which were read from the database before an
+	// invalidation, but only added to the cache afterwards.
+	generation uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// Define a permissionCacheEntry struct to hold the cached permissions for a user and
+// the time that they expire.
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// The NewPermissionCache() function returns a new PermissionCache with the given TTL,
+// and launches a background goroutine which removes expired entries once every minute.
+// It returns nil (which disables caching) if the TTL isn't positive.
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	c := &PermissionCache{
+		ttl:     ttl,
+		entries: make(map[int64]permissionCacheEntry),
+	}
+
+	go func() {
+
+		for {
+
+			time.Sleep(time.Minute)
+
+			c.mu.Lock()
+
+			for userID, entry := range c.entries {
+				if time.Now().After(entry.expiry) {
+					delete(c.entries, userID)
+				}
+			}
+
+			c.mu.Unlock()
+		}
+	}()
+
+	return c
+}
+
+// The get() method returns the cached permissions for a user, along with the current
+// generation. The bool is false if there's no unexpired entry for the user.
+func (c *PermissionCache) get(userID int64) (Permissions, uint64, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[userID]
+	if !found || time.Now().After(entry.expiry) {
+		c.misses.Add(1)
+		return nil, c.generation, false
+	}
+
+	c.hits.Add(1)
+	return entry.permissions, c.generation, true
+}
+
+// The set() method caches the permissions for a user, unless something has been
+// invalidated since the given generation was returned by get().
+func (c *PermissionCache) set(userID int64, permissions Permissions, generation uint64) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	c.entries[userID] = permissionCacheEntry{
+		permissions: permissions,
+		expiry:      time.Now().Add(c.ttl),
+	}
+}
+
+// The Invalidate() method removes the cached permissions for a user. It's safe to call
+// on a nil *PermissionCache.
+func (c *PermissionCache) Invalidate(userID int64) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.generation++
+}
+
+// The InvalidateAll() method removes the cached permissions for every user. It's safe
+// to call on a nil *PermissionCache.
+func (c *PermissionCache) InvalidateAll() {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.generation++
+}
+
+// The Stats() method returns the number of cache hits and misses so far.
+func (c *PermissionCache) Stats() (hits, misses int64) {
+
+	if c == nil {
+		return 0, 0
+	}
+
+	return c.hits.Load(), c.misses.Load()
+}
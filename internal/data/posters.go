@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// PosterURLPrefix is the path that the API serves poster images from. The URLs in a
+// Poster are this followed by the image's key in the blob store.
+const PosterURLPrefix = "/v1/posters/"
+
+// PosterThumbnails lists the names and widths of the thumbnails that we make of each
+// poster. Thumbnails keep the aspect ratio of the original, and are never larger than
+// it.
+var PosterThumbnails = []struct {
+	Name  string
+	Width int
+}{
+	{"small", 185},
+	{"medium", 342},
+	{"large", 780},
+}
+
+// The Poster struct describes the artwork for a movie. Key is where the original image
+// is kept in the blob store; the thumbnails are kept next to it.
+type Poster struct {
+	Key        string            `json:"-"`
+	URL        string            `json:"url"`
+	Width      int32             `json:"width"`
+	Height     int32             `json:"height"`
+	Thumbnails map[string]string `json:"thumbnails"`
+}
+
+// The PosterThumbnailKey() function returns the key of the named thumbnail for the
+// poster whose original image has the given key. Thumbnails are always JPEGs.
+func PosterThumbnailKey(key, name string) string {
+	return path.Join(path.Dir(key), name+".jpg")
+}
+
+// The Keys() method returns the keys of the original image and all of the thumbnails.
+func (p *Poster) Keys() []string {
+
+	keys := []string{p.Key}
+
+	for _, thumbnail := range PosterThumbnails {
+		keys = append(keys, PosterThumbnailKey(p.Key, thumbnail.Name))
+	}
+
+	return keys
+}
+
+// The setPoster() method fills in the URLs of a poster which has been read from the
+// database and attaches it to the movie. Movies without a poster have an empty key, and
+// their Poster field is left nil so that it's omitted from the JSON.
+func (movie *Movie) setPoster(poster Poster) {
+
+	if poster.Key == "" {
+		movie.Poster = nil
+		return
+	}
+
+	poster.URL = PosterURLPrefix + poster.Key
+	poster.Thumbnails = make(map[string]string, len(PosterThumbnails))
+
+	for _, thumbnail := range PosterThumbnails {
+		poster.Thumbnails[thumbnail.Name] = PosterURLPrefix + PosterThumbnailKey(poster.Key, thumbnail.Name)
+	}
+
+	movie.Poster = &poster
+}
+
+// The ValidatePosterImage() function checks the content type and dimensions of an
+// uploaded poster. The content type must be the one sniffed from the image itself,
+// rather than the one the client claims.
+func ValidatePosterImage(v *validator.Validator, contentType string, width, height int) {
+
+	v.Check(validator.PermittedValue(contentType, "image/jpeg", "image/png"), "poster", "must be a JPEG or PNG image")
+	v.Check(width >= 100 && height >= 100, "poster", "must be at least 100 pixels wide and high")
+	v.Check(width <= 4000 && height <= 4000, "poster", "must not be more than 4000 pixels wide or high")
+}
+
+// The UpdatePoster() method records a movie's new poster. Like Update(), it checks the
+// movie's version number and whether the editor is allowed to change the movie, and it
+// increments the version number.
+func (m MovieModel) UpdatePoster(movie *Movie, poster Poster, editor MovieEditor) error {
+
+	query := `
+		UPDATE movies
+			SET poster_key = $1, poster_width = $2, poster_height = $3, version = version + 1
+		WHERE id = $4 AND version = $5 AND (created_by = $6 OR NOT $7) AND organization_id = $8
+		RETURNING version`
+
+	args := []any{
+		poster.Key,
+		poster.Width,
+		poster.Height,
+		movie.ID,
+		movie.Version,
+		editor.UserID,
+		editor.OwnOnly,
+		m.OrganizationID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	movie.setPoster(poster)
+
+	return nil
+}
@@ -0,0 +1,387 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// Define a custom ErrDuplicateReview error, which is returned when a user tries to
+// review a movie that they have already reviewed.
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// The Review struct holds a user's score for a movie, out of 10, along with an optional
+// written review.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    int64     `json:"user_id"`
+	MovieID   int64     `json:"movie_id"`
+	Score     int32     `json:"score"`
+	Text      string    `json:"text,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// The ValidateReview() function checks the score and text of a review.
+func ValidateReview(v *validator.Validator, review *Review) {
+
+	v.Check(review.Score != 0, "score", "must be provided")
+	v.Check(review.Score >= 1 && review.Score <= 10, "score", "must be between 1 and 10")
+	v.Check(len(review.Text) <= 10_000, "text", "must not be more than 10000 bytes long")
+}
+
+// Define the ReviewModel type. Like the MovieModel, a ReviewModel only works with the
+// reviews of movies in the organization in its OrganizationID field, so handlers must
+// call ForOrganization() to get one.
+type ReviewModel struct {
+	DB             *sql.DB
+	OrganizationID int64
+}
+
+// The ForOrganization() method returns a copy of the ReviewModel which works with the
+// reviews of movies in the given organization.
+func (m ReviewModel) ForOrganization(organizationID int64) ReviewModel {
+	m.OrganizationID = organizationID
+	return m
+}
+
+// The Insert() method adds a new review and updates the movie's average rating and
+// ratings count. It returns ErrRecordNotFound if the movie doesn't exist, and
+// ErrDuplicateReview if the user has already reviewed it.
+func (m ReviewModel) Insert(review *Review) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = m.lockMovie(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO reviews
+			(user_id, movie_id, score, text)
+		VALUES
+			($1, $2, $3, $4)
+		RETURNING
+			id, created_at, updated_at, version`
+
+	args := []any{review.UserID, review.MovieID, review.Score, review.Text}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_user_id_movie_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Get() method returns a single review of a movie.
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT
+			reviews.id, reviews.created_at, reviews.updated_at, reviews.user_id,
+			reviews.movie_id, reviews.score, reviews.text, reviews.version
+		FROM reviews
+			INNER JOIN movies ON movies.id = reviews.movie_id
+		WHERE reviews.id = $1 AND reviews.movie_id = $2 AND movies.organization_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var review Review
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID, m.OrganizationID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.UserID,
+		&review.MovieID,
+		&review.Score,
+		&review.Text,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// The GetAllForMovie() method returns a paginated list of the reviews of a movie.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT
+			count(*) OVER(), reviews.id, reviews.created_at, reviews.updated_at,
+			reviews.user_id, reviews.movie_id, reviews.score, reviews.text, reviews.version
+		FROM reviews
+			INNER JOIN movies ON movies.id = reviews.movie_id
+		WHERE reviews.movie_id = $1 AND movies.organization_id = $2
+		ORDER BY reviews.%s %s, reviews.id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{movieID, m.OrganizationID, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.UserID,
+			&review.MovieID,
+			&review.Score,
+			&review.Text,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// The GetAllWrittenBy() method returns all of the reviews that a specific user has
+// written of movies in the organization, in the order they were written.
+func (m ReviewModel) GetAllWrittenBy(userID int64) ([]*Review, error) {
+
+	query := `
+		SELECT
+			reviews.id, reviews.created_at, reviews.updated_at, reviews.user_id,
+			reviews.movie_id, reviews.score, reviews.text, reviews.version
+		FROM reviews
+			INNER JOIN movies ON movies.id = reviews.movie_id
+		WHERE reviews.user_id = $1 AND movies.organization_id = $2
+		ORDER BY reviews.id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, m.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reviews := []*Review{}
+
+	for rows.Next() {
+
+		var review Review
+
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.UserID,
+			&review.MovieID,
+			&review.Score,
+			&review.Text,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// The Update() method saves the changes to a review, using the version number to check
+// for edit conflicts in the same way as MovieModel.Update(), and updates the movie's
+// average rating.
+func (m ReviewModel) Update(review *Review) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = m.lockMovie(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE reviews
+			SET score = $1, text = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3 AND movie_id = $4 AND version = $5
+		RETURNING updated_at, version`
+
+	args := []any{review.Score, review.Text, review.ID, review.MovieID, review.Version}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method deletes a review and updates the movie's average rating and
+// ratings count.
+func (m ReviewModel) Delete(review *Review) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = m.lockMovie(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1 AND movie_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, review.ID, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The lockMovie() method locks the row for a movie in the model's organization until
+// the end of the transaction, returning ErrRecordNotFound if there's no such movie.
+// Holding the lock while we change a review means that concurrent changes to the
+// reviews of the same movie take turns, so the average rating that the last of them
+// calculates always includes all of the others.
+func (m ReviewModel) lockMovie(ctx context.Context, tx *sql.Tx, movieID int64) error {
+
+	query := `
+		SELECT id
+		FROM movies
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE`
+
+	var id int64
+
+	err := tx.QueryRowContext(ctx, query, movieID, m.OrganizationID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The updateMovieRating() function recalculates the average rating and ratings count
+// of a movie from its reviews. Note that this doesn't change the movie's version
+// number, because the rating isn't something that clients can edit.
+func updateMovieRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+
+	query := `
+		UPDATE movies
+			SET average_rating = COALESCE((SELECT ROUND(AVG(score), 2) FROM reviews WHERE movie_id = $1), 0),
+				ratings_count = (SELECT count(*) FROM reviews WHERE movie_id = $1)
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// The Revocation struct holds the hash and expiry time of an authentication token which
+// was deleted before it expired. Revocations are recorded automatically by a trigger on
+// the tokens table.
+type Revocation struct {
+	ID     int64
+	Hash   []byte
+	Expiry time.Time
+}
+
+// Define the RevocationModel type.
+type RevocationModel struct {
+	DB *sql.DB
+}
+
+// The GetAllUnexpired() method returns all revocations for tokens which haven't expired
+// yet. We reload the whole list each time, rather than just fetching the rows with a
+// higher ID than last time, because bigserial IDs are allocated when a row is inserted
+// but only become visible when the transaction commits. A transaction which took a
+// lower ID could commit after we had already read a higher one, and we'd never see it.
+// The list only contains unexpired tokens, so it stays small.
+func (m RevocationModel) GetAllUnexpired() ([]*Revocation, error) {
+
+	query := `
+		SELECT 
+			id, hash, expiry
+		FROM token_revocations
+		WHERE expiry > $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	revocations := []*Revocation{}
+
+	for rows.Next() {
+
+		var revocation Revocation
+
+		err := rows.Scan(&revocation.ID, &revocation.Hash, &revocation.Expiry)
+		if err != nil {
+			return nil, err
+		}
+
+		revocations = append(revocations, &revocation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revocations, nil
+}
+
+// DeleteExpired() deletes all revocations for tokens which have expired anyway.
+func (m RevocationModel) DeleteExpired() error {
+
+	query := `
+		DELETE FROM token_revocations
+		WHERE expiry < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now())
+	return err
+}
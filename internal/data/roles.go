@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The Role struct holds a named bundle of permission codes. Permissions holds the codes
+// granted to the role directly, and Inherits holds the names of the roles whose
+// permissions it inherits.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+	Inherits    []string    `json:"inherits"`
+}
+
+// Define the RoleModel type. Changing a user's roles changes their permissions, so the
+// model clears their cached permissions from the same cache as the PermissionModel.
+type RoleModel struct {
+	DB    *sql.DB
+	Cache *PermissionCache
+}
+
+// The GetAll() method returns all roles, along with their permissions and the names of
+// the roles they inherit from.
+func (m RoleModel) GetAll() ([]*Role, error) {
+
+	query := `
+		SELECT 
+			roles.id, roles.name, roles.description,
+			ARRAY(
+				SELECT permissions.code
+				FROM permissions
+					INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+				WHERE roles_permissions.role_id = roles.id
+				ORDER BY permissions.code
+			),
+			ARRAY(
+				SELECT parents.name
+				FROM roles AS parents
+					INNER JOIN roles_inheritance ON roles_inheritance.parent_id = parents.id
+				WHERE roles_inheritance.role_id = roles.id
+				ORDER BY parents.name
+			)
+		FROM roles
+		ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+
+		var role Role
+
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			pq.Array(&role.Permissions),
+			pq.Array(&role.Inherits),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// The Exists() method reports whether a role with the given name exists.
+func (m RoleModel) Exists(name string) (bool, error) {
+
+	query := `
+		SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&exists)
+	return exists, err
+}
+
+// The GetAllForUser() method returns the names of the roles which have been given to a
+// specific user. It doesn't include the roles that they inherit from.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+
+	query := `
+		SELECT 
+			roles.name
+		FROM roles
+			INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+
+		var role string
+
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Give the named roles to a specific user. Roles which the user already has are
+// ignored.
+func (m RoleModel) AddForUser(userID int64, names ...string) error {
+
+	query := `
+		INSERT INTO users_roles
+			SELECT $1, roles.id 
+			FROM roles 
+			WHERE roles.name = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+
+	return nil
+}
+
+// Take the named roles away from a specific user.
+func (m RoleModel) RemoveForUser(userID int64, names ...string) error {
+
+	query := `
+		DELETE FROM users_roles
+		WHERE user_id = $1 AND role_id IN (
+			SELECT roles.id 
+			FROM roles 
+			WHERE roles.name = ANY($2)
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+
+	return nil
+}
+
+// Replace all of a user's roles with the named roles, in a transaction.
+func (m RoleModel) SetForUser(userID int64, names ...string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_roles WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO users_roles
+			SELECT $1, roles.id 
+			FROM roles 
+			WHERE roles.name = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.Cache.Invalidate(userID)
+
+	return nil
+}
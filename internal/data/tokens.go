@@ -6,9 +6,11 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/high-la/greenlight/internal/validator"
+	"github.com/lib/pq"
 )
 
 // Define constants for the token scope. For now we just define the scope "activation"
@@ -16,19 +18,63 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopePersonalAccess = "personal-access"
+	ScopeLogin          = "login"
+	ScopeEmailChange    = "email-change"
+)
+
+// Personal access tokens are prefixed so that they are easy to recognise (both by us,
+// and by secret scanners if one is accidentally committed to a repository).
+const PersonalAccessTokenPrefix = "glpat_"
+
+// Define a custom ErrTokenReused error, which is returned when a refresh token that has
+// already been rotated is presented again.
+var (
+	ErrTokenReused = errors.New("token reused")
 )
 
 // Define a Token struct  to hols the data for an individual token. This includes the
 // plaintext and hashed versions of the token, associated user ID, expiry time and
-// scope
-
-// Add struct tags to control how the struct appears when encoded to JSON.
+// scope. Add struct tags to control how the struct appears when encoded to JSON.
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	// Alongside the basic token data, the ID, CreatedAt, LastUsedAt, IP and UserAgent
+	// fields hold the session metadata for authentication tokens, so that users can see
+	// which devices are logged in. Note that the plaintext token is only ever known
+	// when the token is first created, so we omit it from the JSON when it is empty
+	// (for example, when listing a user's sessions).
+	ID         int64      `json:"id,omitempty"`
+	Plaintext  string     `json:"token,omitempty"`
+	Hash       []byte     `json:"-"`
+	UserID     int64      `json:"-"`
+	CreatedAt  time.Time  `json:"created_at,omitzero"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Expiry     time.Time  `json:"expiry"`
+	Scope      string     `json:"-"`
+	IP         string     `json:"ip,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+
+	// The Family field links together all of the authentication and refresh tokens
+	// which descend from a single login, so that they can be revoked as a group.
+	Family []byte `json:"-"`
+
+	// The Name and Scopes fields are used by personal access tokens. Scopes holds the
+	// permission codes that the token is restricted to.
+	Name   string   `json:"name,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+
+	// The Email field holds the new email address for email change tokens.
+	Email string `json:"-"`
+
+	// The organization that the user is working in with this token, or zero if they
+	// aren't working in any organization. This is only set for authentication, refresh
+	// and personal access tokens.
+	OrganizationID int64 `json:"organization_id,omitempty"`
+
+	// The ID of the member of staff who is impersonating the user with this token, or
+	// zero if the token isn't being used for impersonation.
+	ImpersonatorID int64 `json:"impersonator_id,omitempty"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -81,6 +127,18 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
+// Check that a personal access token has a sensible name and set of scopes, and that
+// its expiry time is in the future but not more than a year away.
+func ValidatePersonalAccessToken(v *validator.Validator, token *Token) {
+
+	v.Check(token.Name != "", "name", "must be provided")
+	v.Check(len(token.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(token.Scopes) >= 1, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(token.Scopes), "scopes", "must not contain duplicate values")
+	v.Check(token.Expiry.After(time.Now()), "expiry", "must be in the future")
+	v.Check(token.Expiry.Before(time.Now().AddDate(1, 0, 1)), "expiry", "must not be more than 1 year in the future")
+}
+
 // Define the TokenModel
 type TokenModel struct {
 	DB *sql.DB
@@ -99,21 +157,405 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// The generateSessionTokens() function generates a new authentication (access) token
+// and refresh token pair in the given token family and organization, recording the IP
+// address and User-Agent of the client that they were issued to.
+func generateSessionTokens(userID, organizationID int64, accessTTL, refreshTTL time.Duration, family []byte, ip, userAgent string) (*Token, *Token, error) {
+
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.Family = family
+		token.OrganizationID = organizationID
+		token.IP = ip
+		token.UserAgent = userAgent
+	}
+
+	return access, refresh, nil
+}
+
+// The NewSession() method starts a new login session in the given organization. It
+// creates a short-lived authentication token and a long-lived refresh token in a brand
+// new token family, and records the IP address and User-Agent of the client that they
+// were issued to.
+func (m TokenModel) NewSession(userID, organizationID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*Token, *Token, error) {
+
+	// Token families are identified by 16 random bytes, in the same way as tokens
+	// themselves.
+	family := make([]byte, 16)
+
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := generateSessionTokens(userID, organizationID, accessTTL, refreshTTL, family, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Insert both tokens inside a transaction, so that we never end up with one
+	// without the other.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer tx.Rollback()
+
+	for _, token := range []*Token{access, refresh} {
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// The Rotate() method exchanges a refresh token for a new authentication and refresh
+// token pair in the same token family. Each refresh token can only be used once. If a
+// refresh token which has already been rotated is presented again, then we assume that
+// it has been stolen: we revoke every token in the family and return ErrTokenReused.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*Token, *Token, error) {
+
+	refreshHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer tx.Rollback()
+
+	// Lock the row for the refresh token using FOR UPDATE, so that if the same refresh
+	// token is presented by two concurrent requests only one of them can rotate it.
+	query := `
+		SELECT 
+			user_id, COALESCE(organization_id, 0), family, expiry, rotated_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2
+		FOR UPDATE`
+
+	var (
+		userID         int64
+		organizationID int64
+		family         []byte
+		expiry         time.Time
+		rotatedAt      *time.Time
+	)
+
+	err = tx.QueryRowContext(ctx, query, refreshHash[:], ScopeRefresh).Scan(&userID, &organizationID, &family, &expiry, &rotatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	// If the refresh token has been used before, revoke the whole token family and
+	// commit the transaction before returning the ErrTokenReused error.
+	if rotatedAt != nil {
+
+		query = `
+			DELETE FROM tokens
+			WHERE family = $1`
+
+		_, err = tx.ExecContext(ctx, query, family)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrTokenReused
+	}
+
+	if !expiry.After(time.Now()) {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	// Mark the refresh token as rotated. Note that we don't delete it, because we
+	// need to be able to recognise it if it is ever presented again.
+	query = `
+		UPDATE tokens
+			SET rotated_at = NOW()
+		WHERE hash = $1`
+
+	_, err = tx.ExecContext(ctx, query, refreshHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := generateSessionTokens(userID, organizationID, accessTTL, refreshTTL, family, ip, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// The NewPersonalAccess() method creates a new named personal access token, which is
+// restricted to the given permission scopes and organization.
+func (m TokenModel) NewPersonalAccess(userID, organizationID int64, ttl time.Duration, name string, scopes []string) (*Token, error) {
+
+	token, err := generateToken(userID, ttl, ScopePersonalAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add the prefix to the plaintext token, and recalculate the hash to match.
+	token.Plaintext = PersonalAccessTokenPrefix + token.Plaintext
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	token.Name = name
+	token.Scopes = scopes
+	token.OrganizationID = organizationID
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// The NewImpersonation() method creates an authentication token which lets a member of
+// staff act as another user. There is no refresh token, so the impersonation ends when
+// the token expires.
+func (m TokenModel) NewImpersonation(userID, impersonatorID, organizationID int64, ttl time.Duration, ip, userAgent string) (*Token, error) {
+
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ImpersonatorID = impersonatorID
+	token.OrganizationID = organizationID
+	token.IP = ip
+	token.UserAgent = userAgent
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// The NewEmailChange() method creates a new token which, when used, changes the user's
+// email address to the given address.
+func (m TokenModel) NewEmailChange(userID int64, ttl time.Duration, email string) (*Token, error) {
+
+	token, err := generateToken(userID, ttl, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Email = email
+
+	err = m.Insert(token)
+	return token, err
+}
+
+// The GetEmailChange() method retrieves an unexpired email change token by its
+// plaintext value, so that we know which user it belongs to and which email address
+// they want to change to. If there's no matching token we return ErrRecordNotFound.
+func (m TokenModel) GetEmailChange(tokenPlaintext string) (*Token, error) {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT 
+			id, hash, user_id, created_at, expiry, scope, email
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeEmailChange, time.Now()).Scan(
+		&token.ID,
+		&token.Hash,
+		&token.UserID,
+		&token.CreatedAt,
+		&token.Expiry,
+		&token.Scope,
+		&token.Email,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
 // Insert() adds the data for specific token to the tokens table
 func (m TokenModel) Insert(token *Token) error {
 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertToken(ctx, m.DB, token)
+}
+
+// The queryRower interface is satisfied by both *sql.DB and *sql.Tx, which lets us use
+// the insertToken() function inside and outside of transactions.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// The insertToken() function adds the data for a specific token to the tokens table,
+// and scans the system generated ID and creation time into the Token struct.
+func insertToken(ctx context.Context, q queryRower, token *Token) error {
+
 	query := `INSERT INTO tokens 
-				(hash, user_id, expiry, scope)
+				(hash, user_id, expiry, scope, ip, user_agent, family, name, scopes, email, organization_id,
+				impersonator_id)
 			  VALUES 
-			  	($1, $2, $3, $4)`
+			  	($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, 0), NULLIF($12, 0))
+			  RETURNING
+			  	id, created_at`
+
+	args := []any{
+		token.Hash,
+		token.UserID,
+		token.Expiry,
+		token.Scope,
+		token.IP,
+		token.UserAgent,
+		token.Family,
+		token.Name,
+		pq.Array(token.Scopes),
+		token.Email,
+		token.OrganizationID,
+		token.ImpersonatorID,
+	}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+// The SetOrganization() method switches the session that an authentication token (which
+// is identified by its hash) belongs to into a different organization. We change every token in the token family,
+// so that the refresh token (and so every authentication token issued from it later)
+// stays in the new organization too. It returns the authentication token, without its
+// plaintext.
+//
+// Signed authentication tokens carry the organization in their claims, so changing the
+// row isn't enough to stop the old token being used in the old organization. If reissue
+// is true, we replace the authentication token with a new one (with a fresh plaintext,
+// but otherwise the same) and delete the old one, so that it's recorded as revoked. In
+// that case the new token is returned, including its plaintext.
+func (m TokenModel) SetOrganization(tokenHash []byte, organizationID int64, reissue bool) (*Token, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		UPDATE tokens
+			SET organization_id = NULLIF($1, 0)
+		WHERE hash = $2 AND scope = $3 AND expiry > NOW()
+		RETURNING 
+			id, user_id, created_at, expiry, scope, ip, user_agent, family, COALESCE(impersonator_id, 0)`
+
+	var token Token
+
+	err = tx.QueryRowContext(ctx, query, organizationID, tokenHash, ScopeAuthentication).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.CreatedAt,
+		&token.Expiry,
+		&token.Scope,
+		&token.IP,
+		&token.UserAgent,
+		&token.Family,
+		&token.ImpersonatorID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if token.Family != nil {
+
+		query = `
+			UPDATE tokens
+				SET organization_id = NULLIF($1, 0)
+			WHERE family = $2`
+
+		_, err = tx.ExecContext(ctx, query, organizationID, token.Family)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token.OrganizationID = organizationID
+
+	if !reissue {
+		return &token, tx.Commit()
+	}
+
+	replacement, err := generateToken(token.UserID, time.Until(token.Expiry), ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement.Expiry = token.Expiry
+	replacement.IP = token.IP
+	replacement.UserAgent = token.UserAgent
+	replacement.Family = token.Family
+	replacement.OrganizationID = token.OrganizationID
+	replacement.ImpersonatorID = token.ImpersonatorID
+
+	err = insertToken(ctx, tx, replacement)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only delete the old authentication token itself, not the rest of its family.
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE id = $1`, token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return replacement, tx.Commit()
 }
 
 // DeleteAllForUser() deletes all tokens for a specific user and scope
@@ -130,4 +572,163 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
-//
+// DeleteAllScopesForUser() deletes every token for a specific user, whatever its scope.
+func (m TokenModel) DeleteAllScopesForUser(userID int64) error {
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Delete() deletes a specific token, identified by its scope and hash, along with any
+// other tokens in the same token family (such as the refresh token that was issued
+// alongside an authentication token).
+func (m TokenModel) Delete(scope string, tokenHash []byte) error {
+
+	// Note that if the token doesn't belong to a family then the subquery returns
+	// NULL, and the 'family = NULL' condition is never true.
+	query := `
+		DELETE FROM tokens
+		WHERE (scope = $1 AND hash = $2)
+			OR family = (SELECT family FROM tokens WHERE scope = $1 AND hash = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash)
+	return err
+}
+
+// DeleteOtherSessions() deletes all of a user's authentication and refresh tokens,
+// except for those in the same token family as the authentication token with the given
+// hash. We use
+// this to log a user out everywhere except on the device that they're currently using.
+func (m TokenModel) DeleteOtherSessions(userID int64, tokenHash []byte) error {
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope IN ($2, $3) AND hash <> $4
+			AND family IS DISTINCT FROM (SELECT family FROM tokens WHERE hash = $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, tokenHash)
+	return err
+}
+
+// Touch() records that the token with the given hash has just been used, along with the
+// IP address and User-Agent of the client that used it.
+func (m TokenModel) Touch(tokenHash []byte, ip, userAgent string) error {
+
+	query := `
+		UPDATE tokens
+			SET last_used_at = NOW(), ip = $2, user_agent = $3
+		WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash, ip, userAgent)
+	return err
+}
+
+// GetAllForUser() returns all unexpired tokens for a specific user and scope, with the
+// most recently used tokens first. Where several tokens belong to the same token family
+// (because the session has been refreshed), only the newest one is returned.
+func (m TokenModel) GetAllForUser(scope string, userID int64) ([]*Token, error) {
+
+	query := `
+		SELECT 
+			id, hash, user_id, created_at, last_used_at, expiry, scope, ip, user_agent, family,
+			name, scopes, COALESCE(impersonator_id, 0)
+		FROM (
+			SELECT DISTINCT ON (COALESCE(family, hash)) *
+			FROM tokens
+			WHERE scope = $1 AND user_id = $2 AND expiry > $3
+			ORDER BY COALESCE(family, hash), id DESC
+		) AS latest
+		ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, scope, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+
+		var token Token
+
+		err := rows.Scan(
+			&token.ID,
+			&token.Hash,
+			&token.UserID,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.Expiry,
+			&token.Scope,
+			&token.IP,
+			&token.UserAgent,
+			&token.Family,
+			&token.Name,
+			pq.Array(&token.Scopes),
+			&token.ImpersonatorID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeleteForUser() deletes a specific token by ID, along with any other tokens in the
+// same token family, so long as it has the given scope and belongs to the given user. If
+// there's no matching token we return ErrRecordNotFound.
+func (m TokenModel) DeleteForUser(scope string, id, userID int64) error {
+
+	query := `
+		DELETE FROM tokens
+		WHERE user_id = $3 AND (
+			(scope = $1 AND id = $2)
+			OR family = (SELECT family FROM tokens WHERE scope = $1 AND id = $2 AND user_id = $3)
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, scope, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/high-la/greenlight/internal/validator"
+)
+
+// The TOTP struct holds a user's time-based one-time password (two-factor
+// authentication) settings. A secret isn't used to protect the account until the user
+// has confirmed that their authenticator app is set up correctly.
+type TOTP struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// Check that a one-time password has been provided and is exactly 6 digits long.
+func ValidateTOTPCode(v *validator.Validator, code string) {
+
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6 && strings.Trim(code, "0123456789") == "", "code", "must be 6 digits long")
+}
+
+// Define the TOTPModel type.
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// The Set() method stores a new, unconfirmed, secret for a user, replacing any
+// unconfirmed secret that they already have. If the user already has a confirmed
+// secret, then it's left alone and we return ErrEditConflict.
+func (m TOTPModel) Set(userID int64, secret string) error {
+
+	query := `
+		INSERT INTO totp_secrets 
+			(user_id, secret)
+		VALUES 
+			($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+			WHERE totp_secrets.confirmed = false`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// The GetForUser() method returns the TOTP settings for a specific user, or
+// ErrRecordNotFound if they haven't started enrolling.
+func (m TOTPModel) GetForUser(userID int64) (*TOTP, error) {
+
+	query := `
+		SELECT 
+			user_id, created_at, secret, confirmed, last_used_step
+		FROM totp_secrets
+		WHERE user_id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.CreatedAt,
+		&totp.Secret,
+		&totp.Confirmed,
+		&totp.LastUsedStep,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// The Confirm() method marks a user's secret as confirmed and replaces their recovery
+// codes, all inside a single transaction.
+func (m TOTPModel) Confirm(userID int64, recoveryCodes []string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE totp_secrets SET confirmed = true WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	// Like tokens, we only store the SHA-256 hash of each recovery code.
+	for _, code := range recoveryCodes {
+		hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hash[:], userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// The UseStep() method records that a code from the given time step has been used. It
+// returns false if a code from the same (or a later) time step has already been used,
+// which means that the code is being replayed.
+func (m TOTPModel) UseStep(userID, step int64) (bool, error) {
+
+	query := `
+		UPDATE totp_secrets
+			SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// The UseRecoveryCode() method deletes a recovery code for a user, returning true if
+// the code existed. Deleting the code means that it can only be used once.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+	query := `
+		DELETE FROM recovery_codes
+		WHERE hash = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash[:], userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// The Delete() method turns off two-factor authentication for a user, deleting their
+// secret and recovery codes.
+func (m TOTPModel) Delete(userID int64) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_secrets WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The normalizeRecoveryCode() function lowercases a recovery code and removes any
+// whitespace, so that codes are accepted however the user types them.
+func normalizeRecoveryCode(code string) string {
+
+	return strings.ToLower(strings.Join(strings.Fields(code), ""))
+}
@@ -5,9 +5,11 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/high-la/greenlight/internal/validator"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -54,6 +56,46 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// Retrieve the details of a specific user by ID.
+func (m UserModel) Get(id int64) (*User, error) {
+
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT 
+			id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE id = $1 AND deleted_at IS NULL`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 // .
 func (m UserModel) GetByEmail(email string) (*User, error) {
 
@@ -61,7 +103,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		SELECT 
 			id, created_at, name, email, password_hash, activated, version
 		FROM users
-		WHERE email = $1`
+		WHERE email = $1 AND deleted_at IS NULL`
 
 	var user User
 
@@ -131,6 +173,180 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// The GetAll() method returns a paginated list of users. If search is not empty, then
+// only users whose name or email address contains it are returned, and if activated is
+// not nil then only users with that activation status are returned.
+func (m UserModel) GetAll(search string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+
+	query := fmt.Sprintf(`
+		SELECT 
+			count(*) OVER(), id, created_at, name, email, password_hash, activated, version
+		FROM users
+		WHERE deleted_at IS NULL
+		AND (name ILIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND (activated = $2 OR $2 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{search, activated, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// The SoftDelete() method marks a user as deleted and deletes all of their tokens, so
+// that they are logged out everywhere and can no longer log in. The user record itself
+// is kept until PurgeDeleted() is called after the grace period, but its email address
+// no longer counts towards the unique index on email, so it can be used to register a
+// new account straight away. As with Update(), we check the version number to prevent
+// race conditions.
+func (m UserModel) SoftDelete(user *User) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+			SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The PurgeDeleted() method permanently deletes all users who were soft deleted before
+// the given time, and returns the number of users deleted. Their tokens, permissions
+// and other records are removed by the ON DELETE CASCADE constraints. That includes
+// their reviews, so we also recalculate the ratings of the movies that they reviewed.
+func (m UserModel) PurgeDeleted(before time.Time) (int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	// Find the movies that the users reviewed before their reviews are deleted.
+	query := `
+		SELECT DISTINCT reviews.movie_id
+		FROM reviews
+			INNER JOIN users ON users.id = reviews.user_id
+		WHERE users.deleted_at < $1`
+
+	rows, err := tx.QueryContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	movieIDs := []int64{}
+
+	for rows.Next() {
+
+		var movieID int64
+
+		err := rows.Scan(&movieID)
+		if err != nil {
+			return 0, err
+		}
+
+		movieIDs = append(movieIDs, movieID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	query = `
+		DELETE FROM users
+		WHERE deleted_at < $1`
+
+	result, err := tx.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, movieID := range movieIDs {
+		err = updateMovieRating(ctx, tx, movieID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return deleted, tx.Commit()
+}
+
 // Declare a new AnonymousUser variable
 
 var AnonymousUser = &User{}
@@ -153,6 +369,10 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
+
+	// If the request was made by a member of staff who is impersonating this user, then
+	// ImpersonatorID holds the ID of the member of staff. Otherwise it is zero.
+	ImpersonatorID int64 `json:"-"`
 }
 
 // Create a custom password type which is a struct containing the plaintext and hashed
@@ -251,7 +471,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 			ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
 			AND tokens.scope = $2
-			AND tokens.expiry > $3`
+			AND tokens.expiry > $3
+			AND users.deleted_at IS NULL`
 
 	// Create a slice containing the query arguments. Notice how we use the [:] operator
 	// to get a slice containing the token hash, rather than passing in the array (which
@@ -288,3 +509,117 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Return the matching user
 	return &user, nil
 }
+
+// The GetForAuthenticationToken() method is like GetForToken(), except that it looks up
+// an authentication token and also returns the ID of the organization that the token is
+// working in. If the user is no longer a member of that organization, then it returns
+// zero instead. If the token was issued to somebody impersonating the user, then the
+// returned user's ImpersonatorID field is set.
+func (m UserModel) GetForAuthenticationToken(tokenPlaintext string) (*User, int64, error) {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT 
+			users.id, users.created_at, users.name, users.email,
+			users.password_hash, users.activated, users.version,
+			COALESCE(tokens.impersonator_id, 0),
+			COALESCE(organization_members.organization_id, 0)
+		FROM users
+			INNER JOIN tokens
+			ON users.id = tokens.user_id
+			LEFT JOIN organization_members
+			ON organization_members.organization_id = tokens.organization_id
+				AND organization_members.user_id = users.id
+		WHERE tokens.hash = $1
+			AND tokens.scope = $2
+			AND tokens.expiry > $3
+			AND users.deleted_at IS NULL`
+
+	args := []any{tokenHash[:], ScopeAuthentication, time.Now()}
+
+	var user User
+	var organizationID int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.ImpersonatorID,
+		&organizationID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+
+	return &user, organizationID, nil
+}
+
+// The GetForPersonalAccessToken() method is like GetForAuthenticationToken(), except
+// that it looks up a personal access token and also returns the permission scopes that
+// the token is restricted to.
+func (m UserModel) GetForPersonalAccessToken(tokenPlaintext string) (*User, Permissions, int64, error) {
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT 
+			users.id, users.created_at, users.name, users.email,
+			users.password_hash, users.activated, users.version, tokens.scopes,
+			COALESCE(organization_members.organization_id, 0)
+		FROM users
+			INNER JOIN tokens
+			ON users.id = tokens.user_id
+			LEFT JOIN organization_members
+			ON organization_members.organization_id = tokens.organization_id
+				AND organization_members.user_id = users.id
+		WHERE tokens.hash = $1
+			AND tokens.scope = $2
+			AND tokens.expiry > $3
+			AND users.deleted_at IS NULL`
+
+	args := []any{tokenHash[:], ScopePersonalAccess, time.Now()}
+
+	var user User
+	var scopes Permissions
+	var organizationID int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		pq.Array(&scopes),
+		&organizationID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, 0, ErrRecordNotFound
+		default:
+			return nil, nil, 0, err
+		}
+	}
+
+	return &user, scopes, organizationID, nil
+}
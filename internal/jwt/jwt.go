@@ -0,0 +1,167 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Define the errors that the Verify() method can return.
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+)
+
+// The header struct holds the JOSE header of a token. We only ever issue and accept
+// tokens signed with HMAC-SHA256, and the kid (key ID) field tells us which of our keys
+// was used to sign the token.
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// The Claims struct holds the payload of a token. As well as the standard jti, sub, iat
+// and exp claims, we include the activation status and permissions of the user, the
+// organization that they are working in and the member of staff impersonating them (if
+// any), so that requests can be authorized without looking them up in the database. Note that the ",string" directive encodes the user
+// ID as a JSON string, as required for the sub claim.
+type Claims struct {
+	ID             string   `json:"jti"`
+	UserID         int64    `json:"sub,string"`
+	IssuedAt       int64    `json:"iat"`
+	Expiry         int64    `json:"exp"`
+	Activated      bool     `json:"activated"`
+	Permissions    []string `json:"permissions"`
+	OrganizationID int64    `json:"org,omitempty"`
+	ImpersonatorID int64    `json:"imp,omitempty"`
+}
+
+// The Signer type signs and verifies tokens. It holds a set of keys indexed by key ID,
+// and the ID of the key which is used to sign new tokens. Any of the keys can be used to
+// verify a token, which means that keys can be rotated by adding a new key, making it
+// the active key, and then removing the old key once all of the tokens signed with it
+// have expired.
+type Signer struct {
+	keys        map[string][]byte
+	activeKeyID string
+}
+
+// The New() function returns a new Signer instance. It returns an error if the active
+// key ID doesn't exist in the keys map, or if any of the keys are too short to be
+// secure.
+func New(activeKeyID string, keys map[string][]byte) (*Signer, error) {
+
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, errors.New("jwt: active key " + activeKeyID + " not found")
+	}
+
+	for id, key := range keys {
+		if len(key) < 32 {
+			return nil, errors.New("jwt: key " + id + " must be at least 32 bytes long")
+		}
+	}
+
+	return &Signer{keys: keys, activeKeyID: activeKeyID}, nil
+}
+
+// The IsSigned() function reports whether a bearer token looks like a signed token
+// (three base64url encoded segments separated by dots), rather than one of our random
+// database-backed tokens.
+func IsSigned(token string) bool {
+
+	return strings.Count(token, ".") == 2
+}
+
+// Sign() encodes the claims and signs them with the active key, returning the token in
+// the compact "<header>.<claims>.<signature>" format.
+func (s *Signer) Sign(claims Claims) (string, error) {
+
+	h, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT", KeyID: s.activeKeyID})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(h) + "." + encode(c)
+
+	return signingInput + "." + encode(sign(s.keys[s.activeKeyID], signingInput)), nil
+}
+
+// Verify() checks the signature and expiry time of a token, returning its claims if it
+// is valid.
+func (s *Signer) Verify(token string) (*Claims, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	// Decode the header and look up the key which was used to sign the token. Because
+	// we only support one algorithm, we reject anything else outright; this protects us
+	// against "alg: none" style attacks.
+	var h header
+
+	err := decode(parts[0], &h)
+	if err != nil || h.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	// Use hmac.Equal() to compare the signatures in constant time.
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	// Only once we know that the signature is valid do we decode the claims.
+	var claims Claims
+
+	err = decode(parts[1], &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// The sign() function returns the HMAC-SHA256 of the signing input.
+func sign(key []byte, signingInput string) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// The encode() function returns the unpadded base64url encoding of b.
+func encode(b []byte) string {
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// The decode() function decodes an unpadded base64url encoded JSON segment into dst.
+func decode(segment string, dst any) error {
+
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Define the errors returned by blob stores when there's no blob with the given key,
+// and when a key isn't valid.
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// The BlobStore interface describes somewhere that we can keep files, like uploaded
+// posters. Keys are slash separated paths, like "posters/42/original.jpg". We only
+// have a filesystem implementation at the moment, but this lets us swap in something
+// like an object store later without changing the handlers.
+type BlobStore interface {
+	// Put stores the contents of r under the given key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	// Open returns the contents of the blob with the given key, or ErrNotFound. The
+	// caller must close it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the blob with the given key. It isn't an error if the blob
+	// doesn't exist.
+	Delete(ctx context.Context, key string) error
+}
+
+// The FileSystem type is a BlobStore which keeps blobs as files under a root directory.
+type FileSystem struct {
+	root string
+}
+
+// The NewFileSystem() function returns a FileSystem which keeps blobs under the given
+// directory, creating it if it doesn't exist yet.
+func NewFileSystem(root string) (*FileSystem, error) {
+
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSystem{root: root}, nil
+}
+
+// The Put() method writes the blob to a temporary file first and then renames it, so
+// that readers never see a partly written blob.
+func (s *FileSystem) Put(ctx context.Context, key string, r io.Reader) error {
+
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	// If anything goes wrong, make sure that the temporary file is removed. Once it has
+	// been renamed, this does nothing.
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// The Open() method opens the file for a blob.
+func (s *FileSystem) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// The Delete() method removes the file for a blob.
+func (s *FileSystem) Delete(ctx context.Context, key string) error {
+
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// The path() method returns the path of the file for a key. Keys come from URLs when
+// we serve blobs, so we reject any key which could refer to a file outside of the root
+// directory, or to one of our temporary files.
+func (s *FileSystem) path(key string) (string, error) {
+
+	if key == "" || !fs.ValidPath(key) || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, ".") {
+			return "", ErrInvalidKey
+		}
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
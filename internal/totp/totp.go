@@ -0,0 +1,118 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Define the parameters that we use for all of our one-time passwords. These are the
+// defaults from RFC 6238, and they are supported by every authenticator app.
+const (
+	period = 30
+	digits = 6
+	skew   = 1
+)
+
+// Secrets are encoded using unpadded base-32, which is what authenticator apps expect.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160-bit secret, encoded as a base-32 string.
+func GenerateSecret() (string, error) {
+
+	secret := make([]byte, 20)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns an otpauth:// URI for the secret, which can be shown to the user as a QR
+// code or opened directly by an authenticator app.
+func URI(secret, issuer, account string) string {
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(digits))
+	params.Set("period", fmt.Sprint(period))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Validate checks a code against the secret at time t. To allow for clock drift, codes
+// from one time step either side of t are also accepted. If the code is valid, Validate
+// returns the time step that it belongs to, so that the caller can prevent the same code
+// from being used twice.
+func Validate(code, secret string, t time.Time) (int64, bool) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := t.Unix() / period
+
+	for step := current - skew; step <= current+skew; step++ {
+		// Use subtle.ConstantTimeCompare() so that we don't leak information about the
+		// expected code through timing differences.
+		if subtle.ConstantTimeCompare([]byte(generate(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// The generate() function returns the code for a specific time step, as described in
+// RFC 4226 (HOTP) section 5.3.
+func generate(key []byte, step int64) string {
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: use the low 4 bits of the last byte as an offset, and read a
+	// 31-bit integer from that position.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1_000_000)
+}
+
+// GenerateRecoveryCodes returns n random single-use recovery codes in the format
+// "xxxxx-xxxxx".
+func GenerateRecoveryCodes(n int) ([]string, error) {
+
+	codes := make([]string, n)
+
+	for i := range codes {
+		b := make([]byte, 6)
+
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(b))
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
@@ -8,6 +8,9 @@ import (
 // Declare a regular expression for sanity checking the format of email ads
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// SlugRX matches URL-friendly slugs, like "acme-pictures".
+	SlugRX = regexp.MustCompile("^[a-z0-9]+(?:-[a-z0-9]+)*$")
 )
 
 // Define a new Validator type which contains a map of validation errors.
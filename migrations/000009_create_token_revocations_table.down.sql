@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS tokens_revocation_trigger ON tokens;
+DROP FUNCTION IF EXISTS record_token_revocation();
+DROP TABLE IF EXISTS token_revocations;
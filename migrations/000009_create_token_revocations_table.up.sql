@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS token_revocations (
+    id bigserial PRIMARY KEY,
+    hash bytea NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+-- Whenever an unexpired authentication token is deleted from the tokens table (no
+-- matter whether that's because the user logged out, a session was revoked, a token
+-- family was revoked or the user was deleted), record its hash in the token_revocations
+-- table. Signed authentication tokens are checked against this list instead of the
+-- tokens table.
+CREATE OR REPLACE FUNCTION record_token_revocation() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO token_revocations (hash, expiry) VALUES (OLD.hash, OLD.expiry);
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tokens_revocation_trigger
+    AFTER DELETE ON tokens
+    FOR EACH ROW
+    WHEN (OLD.scope = 'authentication' AND OLD.expiry > NOW())
+    EXECUTE FUNCTION record_token_revocation();
@@ -0,0 +1,4 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '';
+-- A NULL scopes value means that the token isn't restricted to a subset of the user's
+-- permissions.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS scopes text[];
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS totp_secrets (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    -- The secret has to be stored in a form that we can read back, because we need it to
+    -- calculate the expected codes.
+    secret text NOT NULL,
+    confirmed bool NOT NULL DEFAULT false,
+    -- The time step of the last code that was accepted, so that codes can't be replayed.
+    last_used_step bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE
+);
@@ -0,0 +1,12 @@
+-- Account lockouts are keyed by the SHA-256 hash of the lower case email address, rather
+-- than by user ID, so that we can count failed logins for email addresses which don't
+-- belong to an account too. Otherwise an unknown email address would never be locked,
+-- which would tell an attacker which email addresses have accounts.
+CREATE TABLE IF NOT EXISTS account_lockouts (
+    email_hash bytea PRIMARY KEY,
+    -- The number of failed login attempts since the last successful login (or since the
+    -- last lock was applied).
+    failed_attempts integer NOT NULL DEFAULT 0,
+    last_failed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp(0) with time zone
+);
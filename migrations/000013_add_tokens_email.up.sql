@@ -0,0 +1,3 @@
+-- The new email address for email change tokens, which is only swapped in once the user
+-- has confirmed that they own it.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS email citext NOT NULL DEFAULT '';
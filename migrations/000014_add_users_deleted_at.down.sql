@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS users_email_key;
+
+-- If somebody has registered with the email address of a soft deleted user, then the
+-- deleted user has to be purged early so that the addresses are unique again.
+DELETE FROM users
+WHERE deleted_at IS NOT NULL AND email IN (SELECT email FROM users WHERE deleted_at IS NULL);
+
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
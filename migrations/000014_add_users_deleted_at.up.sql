@@ -0,0 +1,11 @@
+-- Users who have deleted their account are soft deleted by setting deleted_at, and
+-- purged for good once the grace period has passed.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Soft deleted users shouldn't stop somebody from registering with the same email
+-- address during the grace period, so email addresses only have to be unique among the
+-- users who haven't been deleted. The index keeps the name of the old constraint, so
+-- that the UserModel still recognises duplicate email errors.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (email) WHERE deleted_at IS NULL;
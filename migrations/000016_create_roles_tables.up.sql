@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    description text NOT NULL DEFAULT ''
+);
+
+-- A role has all of the permissions of the roles that it inherits from (and of the roles
+-- that they inherit from, and so on).
+CREATE TABLE IF NOT EXISTS roles_inheritance (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    parent_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (role_id, parent_id),
+    CHECK (role_id <> parent_id)
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+-- Add the default roles. Editors inherit everything that viewers can do, and admins
+-- inherit everything that editors can do.
+INSERT INTO roles (name, description)
+VALUES
+('viewer', 'Can view the movie catalog'),
+('editor', 'Can view and edit the movie catalog'),
+('admin', 'Can edit the movie catalog and manage users');
+
+INSERT INTO roles_inheritance (role_id, parent_id)
+SELECT child.id, parent.id
+FROM roles AS child, roles AS parent
+WHERE (child.name, parent.name) IN (('editor', 'viewer'), ('admin', 'editor'));
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.name, permissions.code) IN (
+    ('viewer', 'movies:read'),
+    ('editor', 'movies:write'),
+    ('admin', 'users:admin')
+);
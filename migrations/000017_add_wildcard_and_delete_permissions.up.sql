@@ -0,0 +1,7 @@
+-- Add the movies:delete permission, which is implied by movies:write, and the wildcard
+-- permissions which grant every movies permission and every permission respectively.
+INSERT INTO permissions (code)
+VALUES
+('movies:delete'),
+('movies:*'),
+('*');
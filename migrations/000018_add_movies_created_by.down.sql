@@ -0,0 +1,4 @@
+DELETE FROM roles WHERE name = 'contributor';
+DELETE FROM permissions WHERE code IN ('movies:write:own', 'movies:delete:own');
+DROP INDEX IF EXISTS movies_created_by_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
@@ -0,0 +1,27 @@
+-- Record which user created each movie. If the user's account is deleted then the movie
+-- is kept, but no longer belongs to anyone.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+
+-- Add the permissions for creating movies and changing or deleting only the movies that
+-- the user created themselves.
+INSERT INTO permissions (code)
+VALUES
+('movies:write:own'),
+('movies:delete:own');
+
+-- Add a contributor role for community contributors, who can add movies and fix the
+-- movies that they added, but not change anybody else's.
+INSERT INTO roles (name, description)
+VALUES
+('contributor', 'Can view the movie catalog and edit their own movies');
+
+INSERT INTO roles_inheritance (role_id, parent_id)
+SELECT child.id, parent.id
+FROM roles AS child, roles AS parent
+WHERE child.name = 'contributor' AND parent.name = 'viewer';
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'contributor' AND permissions.code = 'movies:write:own';
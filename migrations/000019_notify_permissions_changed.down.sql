@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS permissions_notify_trigger ON permissions;
+DROP TRIGGER IF EXISTS roles_inheritance_notify_trigger ON roles_inheritance;
+DROP TRIGGER IF EXISTS roles_permissions_notify_trigger ON roles_permissions;
+DROP TRIGGER IF EXISTS users_roles_notify_trigger ON users_roles;
+DROP TRIGGER IF EXISTS users_permissions_notify_trigger ON users_permissions;
+DROP FUNCTION IF EXISTS notify_permissions_changed();
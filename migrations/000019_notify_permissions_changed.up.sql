@@ -0,0 +1,44 @@
+-- Send a notification on the permissions_changed channel whenever a user's permissions
+-- might have changed, so that every instance of the application can clear its cached
+-- copy. The payload is the ID of the user whose permissions changed, or '*' if a
+-- change to a role could affect any number of users. Postgres removes duplicate
+-- notifications within a transaction, so bulk changes don't flood the channel.
+CREATE OR REPLACE FUNCTION notify_permissions_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_TABLE_NAME IN ('users_permissions', 'users_roles') THEN
+        IF TG_OP = 'DELETE' THEN
+            PERFORM pg_notify('permissions_changed', OLD.user_id::text);
+        ELSE
+            PERFORM pg_notify('permissions_changed', NEW.user_id::text);
+        END IF;
+    ELSE
+        PERFORM pg_notify('permissions_changed', '*');
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_permissions_notify_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON users_permissions
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_permissions_changed();
+
+CREATE TRIGGER users_roles_notify_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON users_roles
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_permissions_changed();
+
+CREATE TRIGGER roles_permissions_notify_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON roles_permissions
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_permissions_changed();
+
+CREATE TRIGGER roles_inheritance_notify_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON roles_inheritance
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_permissions_changed();
+
+CREATE TRIGGER permissions_notify_trigger
+    AFTER INSERT OR UPDATE OR DELETE ON permissions
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_permissions_changed();
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'audit:read';
+DROP TRIGGER IF EXISTS audit_events_append_only_trigger ON audit_events;
+DROP FUNCTION IF EXISTS prevent_audit_event_changes();
+DROP TABLE IF EXISTS audit_events;
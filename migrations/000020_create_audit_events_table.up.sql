@@ -0,0 +1,39 @@
+-- The actor_id and target_id columns deliberately don't reference other tables, so that
+-- audit events are kept (and still say who did what) after users or movies have been
+-- deleted.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint,
+    action text NOT NULL,
+    target_type text NOT NULL,
+    target_id text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    changes jsonb
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_type, target_id);
+
+-- The audit log is append-only, so refuse to update or delete any events.
+CREATE OR REPLACE FUNCTION prevent_audit_event_changes() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only_trigger
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_audit_event_changes();
+
+-- Add the permission for reading the audit log, and give it to the admin role.
+INSERT INTO permissions (code)
+VALUES
+('audit:read');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'audit:read';
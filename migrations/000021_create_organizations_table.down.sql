@@ -0,0 +1,4 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS organization_id;
+ALTER TABLE movies DROP COLUMN IF EXISTS organization_id;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    slug citext UNIQUE NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON organization_members (user_id);
+
+-- Put all of the existing users and movies into a default organization, so that
+-- nothing changes for them.
+INSERT INTO organizations (name, slug)
+VALUES
+('Default', 'default');
+
+INSERT INTO organization_members (organization_id, user_id)
+SELECT organizations.id, users.id
+FROM organizations, users
+WHERE organizations.slug = 'default';
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+UPDATE movies SET organization_id = (SELECT id FROM organizations WHERE slug = 'default');
+ALTER TABLE movies ALTER COLUMN organization_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS movies_organization_id_idx ON movies (organization_id);
+
+-- Each session and personal access token records the organization that the user is
+-- currently working in. Existing tokens are put in the default organization too.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS organization_id bigint REFERENCES organizations ON DELETE CASCADE;
+UPDATE tokens SET organization_id = (SELECT id FROM organizations WHERE slug = 'default')
+WHERE scope IN ('authentication', 'refresh', 'personal-access');
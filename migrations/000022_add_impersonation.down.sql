@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'users:impersonate';
+ALTER TABLE audit_events DROP COLUMN IF EXISTS impersonator_id;
+ALTER TABLE tokens DROP COLUMN IF EXISTS impersonator_id;
@@ -0,0 +1,15 @@
+-- Authentication tokens issued to support staff who are impersonating a user record the
+-- ID of the staff member in impersonator_id, and so do the audit events for anything
+-- they do while impersonating.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS impersonator_id bigint REFERENCES users ON DELETE CASCADE;
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS impersonator_id bigint;
+
+-- Add the permission for impersonating users, and give it to the admin role.
+INSERT INTO permissions (code)
+VALUES
+('users:impersonate');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin' AND permissions.code = 'users:impersonate';
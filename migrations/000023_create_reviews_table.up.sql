@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    score smallint NOT NULL,
+    text text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT reviews_user_id_movie_id_key UNIQUE (user_id, movie_id),
+    CONSTRAINT reviews_score_check CHECK (score BETWEEN 1 AND 10)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_idx ON reviews (movie_id);
+
+-- The average score and number of reviews for each movie are kept up to date whenever
+-- a review is created, changed or deleted, so that movies can be sorted by them.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating numeric(4, 2) NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS ratings_count integer NOT NULL DEFAULT 0;
+
+-- Writing reviews needs its own permission, so that a token which can only read the
+-- movie catalog can't change anything. Viewers (and so everybody who inherits from
+-- them) can review movies.
+INSERT INTO permissions (code)
+VALUES
+('reviews:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'viewer' AND permissions.code = 'reviews:write';
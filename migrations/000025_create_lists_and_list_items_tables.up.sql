@@ -0,0 +1,33 @@
+-- Users keep lists of movies in each organization that they belong to. As well as any
+-- number of named ('custom') lists, each user can have one 'watchlist' and one
+-- 'favorites' list per organization. Public lists can be shared using their slug.
+CREATE TABLE IF NOT EXISTS lists (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    kind text NOT NULL DEFAULT 'custom',
+    name text NOT NULL,
+    slug text NOT NULL,
+    public boolean NOT NULL DEFAULT false,
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT lists_slug_key UNIQUE (slug),
+    CONSTRAINT lists_kind_check CHECK (kind IN ('watchlist', 'favorites', 'custom'))
+);
+
+CREATE INDEX IF NOT EXISTS lists_user_id_organization_id_idx ON lists (user_id, organization_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS lists_user_id_organization_id_kind_idx ON lists (user_id, organization_id, kind)
+    WHERE kind <> 'custom';
+
+-- The movies in a list are shown in ascending order of position.
+CREATE TABLE IF NOT EXISTS list_items (
+    list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (list_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS list_items_movie_id_idx ON list_items (movie_id);
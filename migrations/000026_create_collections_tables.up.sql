@@ -0,0 +1,21 @@
+-- Collections group related movies, like the films in a trilogy. Each movie can be in
+-- at most one collection, and the movies in a collection are shown in ascending order
+-- of position.
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS collections_organization_id_idx ON collections (organization_id);
+
+CREATE TABLE IF NOT EXISTS collection_movies (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    position integer NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS collection_movies_collection_id_idx ON collection_movies (collection_id, position);
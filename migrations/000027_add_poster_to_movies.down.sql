@@ -0,0 +1,3 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_height;
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_width;
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_key;
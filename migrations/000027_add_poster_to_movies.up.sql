@@ -0,0 +1,6 @@
+-- The poster_key column holds the blob store key of a movie's original poster image,
+-- or an empty string if the movie doesn't have a poster. The thumbnails are kept next
+-- to it in the blob store.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_key text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_width integer NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_height integer NOT NULL DEFAULT 0;